@@ -1,20 +1,67 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	gnarkLogger "github.com/consensys/gnark/logger"
 	"io"
+	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 	"worldcoin/gnark-mbu/logging"
 	"worldcoin/gnark-mbu/prover"
 	"worldcoin/gnark-mbu/server"
+	"worldcoin/gnark-mbu/server/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 const ProverAddress = "localhost:8080"
 const MetricsAddress = "localhost:9999"
+const GrpcAddress = "localhost:8090"
 
 var mode string
+var provingSystem *prover.ProvingSystem
+
+const insertionParams = `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+
+const insertionParamsStartPath = `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"startPath":["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		}`
+
+const deletionParams = `{
+		"inputHash":"0xdcd389a94b549222fadc9e335c358a3fe4d534155182f46927f82ea8491c7480",
+		"deletionIndices":[0,2],
+		"preRoot":"0xd11eefe87b985333c0d327b0cdd39a9641b5ac32c35c2bda84301ef3231a8ac",
+		"postRoot":"0x1912415186579e1d9ff6282b76d081f0acd527d8549ea803385b1382d9498f35",
+		"identityCommitments":["0x1","0x3"],
+		"merkleProofs":[
+			["0x2","0x20a3af0435914ccd84b806164531b0cd36e37d4efb93efab76913a93e1f30996","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x4","0x65e2c6cc08a36c4a943286bc91c216054a1981eb4f7570f67394ef8937a21b8","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
 
 func TestMain(m *testing.M) {
 	gnarkLogger.Set(*logging.Logger())
@@ -23,13 +70,17 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
+	provingSystem = ps
 	cfg := server.Config{
 		ProverAddress:  ProverAddress,
 		MetricsAddress: MetricsAddress,
 		Mode:           server.InsertionMode,
+		QueueDepth:     1,
+		GrpcAddress:    GrpcAddress,
 	}
 	logging.Logger().Info().Msg("Starting the insertion server")
 	instance := server.Run(&cfg, ps)
+	awaitReady()
 	logging.Logger().Info().Msg("Running the insertion tests")
 	mode = server.InsertionMode
 	m.Run()
@@ -40,8 +91,10 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
+	provingSystem = ps
 	logging.Logger().Info().Msg("Starting the deletion server")
 	instance = server.Run(&cfg, ps)
+	awaitReady()
 	logging.Logger().Info().Msg("Running the deletion tests")
 	mode = server.DeletionMode
 	m.Run()
@@ -49,6 +102,16 @@ func TestMain(m *testing.M) {
 	instance.AwaitStop()
 }
 
+func awaitReady() {
+	for {
+		response, err := http.Get("http://localhost:8080/readyz")
+		if err == nil && response.StatusCode == http.StatusOK {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestWrongMethod(t *testing.T) {
 	response, err := http.Get("http://localhost:8080/prove")
 	if err != nil {
@@ -63,16 +126,7 @@ func TestInsertionHappyPath(t *testing.T) {
 	if mode != server.InsertionMode {
 		return
 	}
-	body := `{
-		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
-		"startIndex":0,
-		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
-		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
-		"identityCommitments":["0x1","0x2"],
-		"merkleProofs": [
-			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
-			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
-		]}`
+	body := insertionParams
 	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
 	if err != nil {
 		t.Fatal(err)
@@ -82,20 +136,33 @@ func TestInsertionHappyPath(t *testing.T) {
 	}
 }
 
+func TestInsertionStartPath(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(insertionParamsStartPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+
+	body := strings.Replace(insertionParamsStartPath, `"preRoot":"0x1`, `"preRoot":"0x2`, 1)
+	response, err = http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
+
 func TestDeletionHappyPath(t *testing.T) {
 	if mode != server.DeletionMode {
 		return
 	}
-	body := `{
-		"inputHash":"0xdcd389a94b549222fadc9e335c358a3fe4d534155182f46927f82ea8491c7480",
-		"deletionIndices":[0,2],
-		"preRoot":"0xd11eefe87b985333c0d327b0cdd39a9641b5ac32c35c2bda84301ef3231a8ac",
-		"postRoot":"0x1912415186579e1d9ff6282b76d081f0acd527d8549ea803385b1382d9498f35",
-		"identityCommitments":["0x1","0x3"],
-		"merkleProofs":[
-			["0x2","0x20a3af0435914ccd84b806164531b0cd36e37d4efb93efab76913a93e1f30996","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
-			["0x4","0x65e2c6cc08a36c4a943286bc91c216054a1981eb4f7570f67394ef8937a21b8","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
-		]}`
+	body := deletionParams
 	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
 	if err != nil {
 		t.Fatal(err)
@@ -126,14 +193,135 @@ func TestInsertionWrongInput(t *testing.T) {
 	if response.StatusCode != http.StatusBadRequest {
 		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
 	}
-	responseBody, err := io.ReadAll(response.Body)
+	expectMerkleProofError(t, response, 0, "0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238")
+}
+
+func TestInsertionInvalidParameters(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	body := strings.Replace(insertionParams, `["0x1","0x2098`, `["0x3","0x2098`, 1)
+	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+	// The second proof has to lead to the root after the first insertion.
+	var responseError server.Error
+	err = json.NewDecoder(response.Body).Decode(&responseError)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !strings.Contains(string(responseBody), "proving_error") {
-		t.Fatalf("Expected error message to be tagged with 'proving_error', got %s", string(responseBody))
+	details := responseError.Details
+	if details == nil || details.Kind != prover.InvalidMerkleProof || details.Slot == nil || *details.Slot != 1 {
+		t.Fatalf("Expected the merkle proof of slot 1 to be reported, got %+v", details)
+	}
+
+	body = strings.Replace(insertionParams, `"inputHash":"0x5`, `"inputHash":"0x6`, 1)
+	response, err = http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(response.Body).Decode(&responseError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details = responseError.Details
+	if details == nil || details.Kind != prover.InvalidInputHash || details.Slot != nil {
+		t.Fatalf("Expected the input hash to be reported, got %+v", details)
+	}
+}
+
+func TestInsertionInvalidParametersBinary(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	for _, c := range []struct {
+		body string
+		kind string
+		slot *int
+	}{
+		{strings.Replace(insertionParams, `["0x1","0x2098`, `["0x3","0x2098`, 1), prover.InvalidMerkleProof, &[]int{1}[0]},
+		{strings.Replace(insertionParams, `"inputHash":"0x5`, `"inputHash":"0x6`, 1), prover.InvalidInputHash, nil},
+	} {
+		var params prover.InsertionParameters
+		err := json.Unmarshal([]byte(c.body), &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = params.WriteBinary(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.Post("http://localhost:8080/prove", server.BinaryContentType, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+		}
+		var responseError server.Error
+		err = json.NewDecoder(response.Body).Decode(&responseError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details := responseError.Details
+		if details == nil || details.Kind != c.kind || (details.Slot == nil) != (c.slot == nil) ||
+			(c.slot != nil && *details.Slot != *c.slot) {
+			t.Fatalf("Expected %s to be reported, got %+v", c.kind, details)
+		}
+	}
+}
+
+func TestInvalidFieldElement(t *testing.T) {
+	modulus := "0x30644e72e131a029b85045b68181585d2833e84879b9709143e1f593f0000001"
+	params := happyPathParams()
+	preRoot := strings.Split(strings.Split(params, `"preRoot":"`)[1], `"`)[0]
+	for _, c := range []struct {
+		body string
+		kind string
+		path string
+	}{
+		{strings.Replace(params, preRoot, modulus, 1), prover.NumberOutOfRange, "preRoot"},
+		{strings.Replace(params, `"identityCommitments":["0x1"`, `"identityCommitments":["-0x1"`, 1), prover.NegativeNumber, "identityCommitments[0]"},
+		{strings.Replace(params, `"identityCommitments":["0x1"`, `"identityCommitments":["0b1"`, 1), prover.MalformedNumber, "identityCommitments[0]"},
+		{strings.Replace(params, `"identityCommitments":["0x1"`, `"identityCommitments":["1_0"`, 1), prover.MalformedNumber, "identityCommitments[0]"},
+	} {
+		response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+		}
+		var responseError server.Error
+		err = json.NewDecoder(response.Body).Decode(&responseError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details := responseError.Details
+		if responseError.Code != "invalid_field_element" || details == nil || details.Kind != c.kind || details.Path != c.path {
+			t.Fatalf("Expected %s field element at %s, got %s %+v", c.kind, c.path, responseError.Code, details)
+		}
 	}
 
+	body := `{"inputHash":"0x1","proof":{"ar":["` + modulus + `","0x1"],"bs":[["0x1","0x1"],["0x1","0x1"]],"krs":["0x1","-0x1"]}}`
+	response, err := http.Post("http://localhost:8080/verify", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responseError server.Error
+	err = json.NewDecoder(response.Body).Decode(&responseError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The coordinates of proofs are in the base field, whose modulus is larger.
+	if responseError.Code != "invalid_field_element" || responseError.Details == nil || responseError.Details.Path != "proof.krs[1]" {
+		t.Fatalf("Expected an invalid field element at proof.krs[1], got %s %+v", responseError.Code, responseError.Details)
+	}
 }
 
 func TestDeletionWrongInput(t *testing.T) {
@@ -157,12 +345,23 @@ func TestDeletionWrongInput(t *testing.T) {
 	if response.StatusCode != http.StatusBadRequest {
 		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
 	}
-	responseBody, err := io.ReadAll(response.Body)
+	expectMerkleProofError(t, response, 0, "0xd11eefe87b985333c0d327b0cdd39a9641b5ac32c35c2bda84301ef3231a8ac")
+}
+
+// expectMerkleProofError checks that the response reports the Merkle proof of
+// slot as not leading to expectedRoot.
+func expectMerkleProofError(t *testing.T, response *http.Response, slot int, expectedRoot string) {
+	var responseError server.Error
+	err := json.NewDecoder(response.Body).Decode(&responseError)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !strings.Contains(string(responseBody), "proving_error") {
-		t.Fatalf("Expected error message to be tagged with 'proving_error', got %s", string(responseBody))
+	if responseError.Code != "invalid_parameters" {
+		t.Fatalf("Expected error code invalid_parameters, got %s", responseError.Code)
+	}
+	details := responseError.Details
+	if details == nil || details.Kind != prover.InvalidMerkleProof || details.Slot == nil || *details.Slot != slot || details.Expected != expectedRoot {
+		t.Fatalf("Expected the merkle proof of slot %d to be reported, got %+v", slot, details)
 	}
 }
 
@@ -188,3 +387,881 @@ func TestDeletionBatchPadding(t *testing.T) {
 		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
 	}
 }
+
+func TestInputHashOmitted(t *testing.T) {
+	params := happyPathParams()
+	var fields map[string]interface{}
+	err := json.Unmarshal([]byte(params), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputHash := fields["inputHash"]
+	delete(fields, "inputHash")
+	body, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.Post("http://localhost:8080/prove", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	var proof map[string]interface{}
+	err = json.NewDecoder(response.Body).Decode(&proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof["inputHash"] != inputHash {
+		t.Fatalf("Expected input hash %s, got %s", inputHash, proof["inputHash"])
+	}
+}
+
+func happyPathParamsBinary(t *testing.T) []byte {
+	var buf bytes.Buffer
+	var err error
+	if mode == server.InsertionMode {
+		var params prover.InsertionParameters
+		err = json.Unmarshal([]byte(insertionParams), &params)
+		if err == nil {
+			err = params.WriteBinary(&buf)
+		}
+	} else {
+		var params prover.DeletionParameters
+		err = json.Unmarshal([]byte(deletionParams), &params)
+		if err == nil {
+			err = params.WriteBinary(&buf)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBinaryHappyPath(t *testing.T) {
+	body := happyPathParamsBinary(t)
+	response, err := http.Post("http://localhost:8080/prove", server.BinaryContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+
+	response, err = http.Post("http://localhost:8080/prove", server.BinaryContentType, bytes.NewReader(body[:len(body)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
+
+func TestWitnessFile(t *testing.T) {
+	var witness *prover.Witness
+	var err error
+	if mode == server.InsertionMode {
+		var params prover.InsertionParameters
+		err = json.Unmarshal([]byte(insertionParams), &params)
+		if err == nil {
+			witness, err = provingSystem.InsertionWitness(&params)
+		}
+	} else {
+		var params prover.DeletionParameters
+		err = json.Unmarshal([]byte(deletionParams), &params)
+		if err == nil {
+			witness, err = provingSystem.DeletionWitness(&params)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{prover.WitnessFormatGnark, prover.WitnessFormatWtns} {
+		var buf bytes.Buffer
+		err = provingSystem.WriteWitness(&buf, witness, format)
+		if err != nil {
+			t.Fatalf("writing %s witness: %s", format, err)
+		}
+		read, err := provingSystem.ReadWitnessFile(&buf, mode)
+		if err != nil {
+			t.Fatalf("reading %s witness: %s", format, err)
+		}
+		proof, err := provingSystem.ReplayWitness(read)
+		if err != nil {
+			t.Fatalf("proving %s witness: %s", format, err)
+		}
+		if mode == server.InsertionMode {
+			err = provingSystem.VerifyInsertion(*proof.InputHash, proof)
+		} else {
+			err = provingSystem.VerifyDeletion(*proof.InputHash, proof)
+		}
+		if err != nil {
+			t.Fatalf("verifying proof of %s witness: %s", format, err)
+		}
+	}
+}
+
+// The witness of a failing batch is replayed up to the constraint solver,
+// while proving it validates its inputs first.
+func TestFailingWitnessFile(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	var params prover.InsertionParameters
+	err := json.Unmarshal([]byte(strings.Replace(insertionParams, `["0x1","0x2098`, `["0x3","0x2098`, 1)), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness, err := provingSystem.InsertionWitness(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = provingSystem.WriteWitness(&buf, witness, prover.WitnessFormatGnark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := provingSystem.ReadWitnessFile(&buf, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validationErr *prover.ValidationError
+	_, err = provingSystem.ReplayWitness(read)
+	if err == nil || errors.As(err, &validationErr) {
+		t.Fatalf("Expected the constraint solver to fail, got %v", err)
+	}
+	_, err = provingSystem.ProveWitness(read)
+	if !errors.As(err, &validationErr) || validationErr.Kind != prover.InvalidMerkleProof {
+		t.Fatalf("Expected the merkle proof to be reported, got %v", err)
+	}
+}
+
+func happyPathParams() string {
+	if mode == server.InsertionMode {
+		return insertionParams
+	}
+	return deletionParams
+}
+
+func awaitJob(t *testing.T, id string) server.ProofJob {
+	for {
+		response, err := http.Get("http://localhost:8080/jobs/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+		}
+		var job server.ProofJob
+		err = json.NewDecoder(response.Body).Decode(&job)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != server.JobQueued && job.Status != server.JobRunning {
+			return job
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestJobHappyPath(t *testing.T) {
+	response, err := http.Post("http://localhost:8080/jobs", "application/json", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, response.StatusCode)
+	}
+	var submitted server.ProofJob
+	err = json.NewDecoder(response.Body).Decode(&submitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := awaitJob(t, submitted.ID)
+	if job.Status != server.JobCompleted || job.Proof == nil {
+		t.Fatalf("Expected job to complete with a proof, got status %s", job.Status)
+	}
+
+	request, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/jobs/"+submitted.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, response.StatusCode)
+	}
+	response, err = http.Get("http://localhost:8080/jobs/" + submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func TestJobRetention(t *testing.T) {
+	cfg := server.Config{
+		ProverAddress:  "localhost:8083",
+		MetricsAddress: "localhost:9994",
+		Mode:           mode,
+		JobRetention:   server.JobRetention{TTL: 2 * time.Second},
+	}
+	instance := server.Run(&cfg, provingSystem)
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	for {
+		response, err := http.Get("http://localhost:8083/readyz")
+		if err == nil && response.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	response, err := http.Post("http://localhost:8083/jobs", "application/json", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var submitted server.ProofJob
+	err = json.NewDecoder(response.Body).Decode(&submitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		response, err = http.Get("http://localhost:8083/jobs/" + submitted.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+		}
+		var job server.ProofJob
+		err = json.NewDecoder(response.Body).Decode(&job)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == server.JobCompleted {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	time.Sleep(cfg.JobRetention.TTL)
+	response, err = http.Get("http://localhost:8083/jobs/" + submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func TestJobStoreMaxFinished(t *testing.T) {
+	dir := t.TempDir()
+	retention := server.JobRetention{MaxFinished: 2}
+	disk, err := server.NewDiskJobStore(dir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []server.JobStore{server.NewMemoryJobStore(retention), disk} {
+		for _, job := range []server.ProofJob{
+			{ID: "01", Status: server.JobRunning},
+			{ID: "02", Status: server.JobCompleted},
+			{ID: "03", Status: server.JobFailed},
+			{ID: "04", Status: server.JobCancelled},
+		} {
+			err = store.Put(&job)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for id, kept := range map[string]bool{"01": true, "02": false, "03": true, "04": true} {
+			_, err = store.Get(id)
+			if kept && err != nil {
+				t.Fatalf("Expected job %s to be kept, got %v", id, err)
+			}
+			if !kept && !errors.Is(err, server.ErrJobNotFound) {
+				t.Fatalf("Expected job %s to be removed, got %v", id, err)
+			}
+		}
+	}
+
+	// The jobs left in the directory count against the retention of the next
+	// store using it, the running one finishing last as it is cancelled.
+	disk, err = server.NewDiskJobStore(dir, server.JobRetention{MaxFinished: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"03", "04"} {
+		if _, err = disk.Get(id); !errors.Is(err, server.ErrJobNotFound) {
+			t.Fatalf("Expected job %s to be removed, got %v", id, err)
+		}
+	}
+	if _, err = disk.Get("01"); err != nil {
+		t.Fatalf("Expected job 01 to be kept, got %v", err)
+	}
+}
+
+func TestDiskJobStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"01.json": `{"id":"01","status":"running"}`,
+		"02.json": `{"id":"02","status":`,
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := server.NewDiskJobStore(dir, server.JobRetention{MaxFinished: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.Get("01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != server.JobCancelled || job.Error == nil || job.Error.Code != "shutting_down" {
+		t.Fatalf("Expected the running job to be cancelled by the shutdown, got %+v", job)
+	}
+	if _, err = store.Get("02"); !errors.Is(err, server.ErrJobNotFound) {
+		t.Fatalf("Expected the corrupted job to be skipped, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "02.json.corrupted")); err != nil {
+		t.Fatalf("Expected the corrupted job to be moved aside, got %v", err)
+	}
+
+	// The cancelled job counts against the retention.
+	err = store.Put(&server.ProofJob{ID: "03", Status: server.JobCompleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get("01"); !errors.Is(err, server.ErrJobNotFound) {
+		t.Fatalf("Expected the cancelled job to be removed, got %v", err)
+	}
+}
+
+func TestJobMalformedBody(t *testing.T) {
+	response, err := http.Post("http://localhost:8080/jobs", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
+
+func TestProveDeadline(t *testing.T) {
+	request, err := http.NewRequest(http.MethodPost, "http://localhost:8080/prove", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(server.ProveTimeoutHeader, "1ns")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status code %d, got %d", http.StatusGatewayTimeout, response.StatusCode)
+	}
+
+	request, err = http.NewRequest(http.MethodPost, "http://localhost:8080/prove", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(server.ProveTimeoutHeader, "soon")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
+
+func TestJobDeadline(t *testing.T) {
+	request, err := http.NewRequest(http.MethodPost, "http://localhost:8080/jobs", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(server.ProveTimeoutHeader, "1ns")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, response.StatusCode)
+	}
+	var submitted server.ProofJob
+	err = json.NewDecoder(response.Body).Decode(&submitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := awaitJob(t, submitted.ID)
+	if job.Status != server.JobFailed || job.Error == nil || job.Error.Code != "deadline_exceeded" {
+		t.Fatalf("Expected job to fail with deadline_exceeded, got status %s", job.Status)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	var accepted []string
+	rejected := false
+	for i := 0; i < 5; i++ {
+		response, err := http.Post("http://localhost:8080/jobs", "application/json", strings.NewReader(happyPathParams()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode == http.StatusTooManyRequests {
+			rejected = true
+			if response.Header.Get("Retry-After") == "" {
+				t.Fatalf("Expected Retry-After header to be set")
+			}
+			continue
+		}
+		if response.StatusCode != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, response.StatusCode)
+		}
+		var job server.ProofJob
+		err = json.NewDecoder(response.Body).Decode(&job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = append(accepted, job.ID)
+	}
+	for _, id := range accepted {
+		awaitJob(t, id)
+	}
+	if !rejected {
+		t.Fatalf("Expected some jobs to be rejected")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	response, err := http.Get("http://localhost:9999/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{"mtb_witness_duration_seconds", "mtb_proving_duration_seconds", "mtb_proofs_abandoned_total", `mtb_request_errors_total{code="proving_error"}`, `mtb_self_tests_total{result="failed"}`} {
+		if !strings.Contains(string(responseBody), metric) {
+			t.Fatalf("Expected metrics to contain %s", metric)
+		}
+	}
+}
+
+func verify(t *testing.T, body string) map[string]interface{} {
+	response, err := http.Post("http://localhost:8080/verify", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	var result map[string]interface{}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	params := happyPathParams()
+	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	proof, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsedParams struct {
+		InputHash string `json:"inputHash"`
+	}
+	err = json.Unmarshal([]byte(params), &parsedParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := verify(t, `{"proof":`+string(proof)+`,"inputHash":"`+parsedParams.InputHash+`"}`)
+	if result["valid"] != true {
+		t.Fatalf("Expected proof to be valid, got %v", result)
+	}
+	result = verify(t, `{"proof":`+string(proof)+`,"parameters":`+params+`}`)
+	if result["valid"] != true {
+		t.Fatalf("Expected proof to be valid, got %v", result)
+	}
+	result = verify(t, `{"proof":`+string(proof)+`,"inputHash":"`+parsedParams.InputHash+`","parameters":null}`)
+	if result["valid"] != true {
+		t.Fatalf("Expected proof to be valid, got %v", result)
+	}
+	result = verify(t, `{"proof":`+string(proof)+`}`)
+	if result["valid"] != true || result["inputHash"] != parsedParams.InputHash {
+		t.Fatalf("Expected proof to be valid for its own input hash, got %v", result)
+	}
+
+	// A proof without its input hash is verified against the one given.
+	var anonymous map[string]interface{}
+	err = json.Unmarshal(proof, &anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(anonymous, "inputHash")
+	anonymousProof, err := json.Marshal(anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = verify(t, `{"proof":`+string(anonymousProof)+`,"inputHash":"0x1"}`)
+	if result["valid"] != false {
+		t.Fatalf("Expected proof to be invalid, got %v", result)
+	}
+
+	response, err = http.Post("http://localhost:8080/verify", "application/json", strings.NewReader(`{"proof":`+string(proof)+`,"inputHash":"0x1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+	var responseError server.Error
+	err = json.NewDecoder(response.Body).Decode(&responseError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responseError.Code != "input_hash_mismatch" {
+		t.Fatalf("Expected the input hashes to mismatch, got %s", responseError.Code)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	for _, endpoint := range []string{"healthz", "readyz"} {
+		response, err := http.Get("http://localhost:8080/" + endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	response, err := http.Get("http://localhost:8080/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	var info struct {
+		Mode             string `json:"mode"`
+		TreeDepth        uint32 `json:"treeDepth"`
+		BatchSize        uint32 `json:"batchSize"`
+		Constraints      int    `json:"constraints"`
+		VerifyingKeyHash string `json:"verifyingKeyHash"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != mode || info.TreeDepth != 3 || info.BatchSize != 2 {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+	if info.Constraints == 0 || len(info.VerifyingKeyHash) != 64 {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+}
+
+func TestNotReady(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	cfg := server.Config{
+		ProverAddress:  "localhost:8081",
+		MetricsAddress: "localhost:9997",
+		Mode:           server.InsertionMode,
+	}
+	system := server.NewSystemHolder()
+	instance := server.RunWithSystem(&cfg, system)
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	for {
+		response, err := http.Get("http://localhost:8081/healthz")
+		if err == nil && response.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	response, err := http.Get("http://localhost:8081/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, response.StatusCode)
+	}
+	response, err = http.Post("http://localhost:8081/prove", "application/json", strings.NewReader(insertionParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, response.StatusCode)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), "not_ready") {
+		t.Fatalf("Expected error message to be tagged with 'not_ready', got %s", string(responseBody))
+	}
+
+	system.Reject(errors.New("self-test proof failed"))
+	response, err = http.Get("http://localhost:8081/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, response.StatusCode)
+	}
+	responseBody, err = io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), "keys_rejected") || !strings.Contains(string(responseBody), "self-test proof failed") {
+		t.Fatalf("Expected error message to be tagged with 'keys_rejected', got %s", string(responseBody))
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	store := server.NewMemoryJobStore(server.JobRetention{})
+	cfg := server.Config{
+		ProverAddress:  "localhost:8082",
+		MetricsAddress: "localhost:9996",
+		Mode:           mode,
+		JobStore:       store,
+		DrainTimeout:   time.Nanosecond,
+	}
+	instance := server.Run(&cfg, provingSystem)
+	for {
+		response, err := http.Get("http://localhost:8082/readyz")
+		if err == nil && response.StatusCode == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	response, err := http.Post("http://localhost:8082/jobs", "application/json", strings.NewReader(happyPathParams()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, response.StatusCode)
+	}
+	var submitted server.ProofJob
+	err = json.NewDecoder(response.Body).Decode(&submitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance.RequestStop()
+	err = instance.AwaitStop()
+	if !errors.Is(err, server.ErrDrainTimeout) {
+		t.Fatalf("Expected drain timeout, got %v", err)
+	}
+	job, err := store.Get(submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != server.JobCancelled {
+		t.Fatalf("Expected job to be cancelled, got status %s", job.Status)
+	}
+}
+
+func TestAddressInUse(t *testing.T) {
+	if mode != server.InsertionMode {
+		return
+	}
+	cfg := server.Config{
+		ProverAddress:  ProverAddress,
+		MetricsAddress: "localhost:9995",
+		Mode:           server.InsertionMode,
+		RestartPolicy:  server.RestartPolicy{MaxRestarts: 1, Backoff: 10 * time.Millisecond},
+	}
+	instance := server.Run(&cfg, provingSystem)
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	select {
+	case <-instance.Failed():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected the server to fail")
+	}
+	var jobErr *server.JobError
+	if !errors.As(instance.Err(), &jobErr) || jobErr.Job != "prover server" {
+		t.Fatalf("Expected the prover server to fail, got %v", instance.Err())
+	}
+}
+
+// A job running for longer than the backoff before failing is restarted every
+// time. The restarts only run out once it fails right away after a restart.
+func TestSuperviseResetsRestarts(t *testing.T) {
+	spawned := 0
+	spawn := func() server.RunningJob {
+		spawned++
+		lifetime := 50 * time.Millisecond
+		if spawned > 4 {
+			lifetime = 0
+		}
+		start := func() error {
+			time.Sleep(lifetime)
+			return errors.New("failed")
+		}
+		return server.SpawnJob("flaky", start, func() error { return nil })
+	}
+	supervised := server.Supervise("flaky", spawn, server.RestartPolicy{MaxRestarts: 1, Backoff: 10 * time.Millisecond})
+	defer func() {
+		supervised.RequestStop()
+		supervised.AwaitStop()
+	}()
+	select {
+	case <-supervised.Failed():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected the job to fail")
+	}
+	if spawned != 5 {
+		t.Fatalf("Expected the job to be spawned 5 times, got %d", spawned)
+	}
+}
+
+func fieldBytes(elements []big.Int) [][]byte {
+	encoded := make([][]byte, len(elements))
+	for i := range elements {
+		encoded[i] = elements[i].Bytes()
+	}
+	return encoded
+}
+
+func merkleProofsProto(proofs [][]big.Int) []*pb.MerkleProof {
+	encoded := make([]*pb.MerkleProof, len(proofs))
+	for i, proof := range proofs {
+		encoded[i] = &pb.MerkleProof{Siblings: fieldBytes(proof)}
+	}
+	return encoded
+}
+
+func happyPathParamsProto(t *testing.T) *pb.Parameters {
+	if mode == server.InsertionMode {
+		var params prover.InsertionParameters
+		err := json.Unmarshal([]byte(insertionParams), &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.Parameters{Parameters: &pb.Parameters_Insertion{Insertion: &pb.InsertionParameters{
+			InputHash:           params.InputHash.Bytes(),
+			StartIndex:          params.StartIndex,
+			PreRoot:             params.PreRoot.Bytes(),
+			PostRoot:            params.PostRoot.Bytes(),
+			IdentityCommitments: fieldBytes(params.IdComms),
+			MerkleProofs:        merkleProofsProto(params.MerkleProofs),
+		}}}
+	}
+	var params prover.DeletionParameters
+	err := json.Unmarshal([]byte(deletionParams), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pb.Parameters{Parameters: &pb.Parameters_Deletion{Deletion: &pb.DeletionParameters{
+		InputHash:           params.InputHash.Bytes(),
+		DeletionIndices:     params.DeletionIndices,
+		PreRoot:             params.PreRoot.Bytes(),
+		PostRoot:            params.PostRoot.Bytes(),
+		IdentityCommitments: fieldBytes(params.IdComms),
+		MerkleProofs:        merkleProofsProto(params.MerkleProofs),
+	}}}
+}
+
+func TestGrpc(t *testing.T) {
+	conn, err := grpc.Dial(GrpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewProverClient(conn)
+	ctx := context.Background()
+
+	info, err := client.GetInfo(ctx, &pb.GetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != mode || info.TreeDepth != 3 || info.BatchSize != 2 || len(info.VerifyingKeyHash) != 32 {
+		t.Fatalf("Unexpected info %v", info)
+	}
+
+	params := happyPathParamsProto(t)
+	proved, err := client.Prove(ctx, &pb.ProveRequest{Parameters: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := client.Verify(ctx, &pb.VerifyRequest{
+		Proof:       proved.Proof,
+		PublicInput: &pb.VerifyRequest_Parameters{Parameters: params},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Valid {
+		t.Fatalf("Expected proof to be valid, got %s", verified.Message)
+	}
+
+	job, err := client.SubmitJob(ctx, &pb.ProveRequest{Parameters: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.WatchJob(ctx, &pb.WatchJobRequest{Id: job.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *pb.Job
+	for {
+		update, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = update
+	}
+	if last == nil || last.Status != pb.JobStatus_JOB_STATUS_COMPLETED || last.Proof == nil {
+		t.Fatalf("Expected job to complete with a proof, got %v", last)
+	}
+
+	_, err = client.Prove(ctx, &pb.ProveRequest{Parameters: &pb.Parameters{}})
+	grpcStatus := status.Convert(err)
+	if grpcStatus.Code() != codes.InvalidArgument || len(grpcStatus.Details()) != 1 {
+		t.Fatalf("Expected invalid argument with error details, got %v", err)
+	}
+	if detail, ok := grpcStatus.Details()[0].(*pb.Error); !ok || detail.Code != "malformed_body" {
+		t.Fatalf("Expected malformed_body error, got %v", grpcStatus.Details())
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
 	"os/signal"
+	"syscall"
 	"worldcoin/gnark-mbu/logging"
 	"worldcoin/gnark-mbu/prover"
 	"worldcoin/gnark-mbu/server"
@@ -70,9 +73,19 @@ func main() {
 					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
 					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
 					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "gnark/iden3", Value: "gnark", Required: false},
+					&cli.StringFlag{Name: "sym-output", Usage: "file for the names of the input wires, in circom's sym format (iden3 format only)", Required: false},
 				},
 				Action: func(context *cli.Context) error {
 					mode := context.String("mode")
+					format := context.String("format")
+					if format != "gnark" && format != "iden3" {
+						return fmt.Errorf("invalid r1cs format: %s", format)
+					}
+					symPath := context.String("sym-output")
+					if symPath != "" && format != "iden3" {
+						return fmt.Errorf("sym-output requires the iden3 format")
+					}
 
 					path := context.String("output")
 					treeDepth := uint32(context.Uint("tree-depth"))
@@ -98,6 +111,27 @@ func main() {
 					if err != nil {
 						return err
 					}
+					if format == "iden3" {
+						err = prover.WriteR1CSIden3(file, cs)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Msg("R1CS written to file")
+						if symPath == "" {
+							return nil
+						}
+						symFile, err := os.Create(symPath)
+						if err != nil {
+							return err
+						}
+						defer symFile.Close()
+						err = prover.WriteSymbols(symFile, mode, treeDepth, batchSize)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Msg("wire names written to file")
+						return nil
+					}
 					written, err := cs.WriteTo(file)
 					if err != nil {
 						return err
@@ -144,6 +178,10 @@ func main() {
 					} else {
 						return fmt.Errorf("Invalid mode: %s", mode)
 					}
+					logging.Logger().Info().Msg("checking keys of the imported proving system")
+					if err := system.CheckKeys(); err != nil {
+						return err
+					}
 					file, err := os.Create(path)
 					defer file.Close()
 					if err != nil {
@@ -157,6 +195,222 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name: "import-zkey",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.StringFlag{Name: "zkey", Usage: "snarkjs zkey set up for the iden3 r1cs of the circuit", Required: true},
+					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", Required: true},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+				},
+				Action: func(context *cli.Context) error {
+					path := context.String("output")
+					zkey := context.String("zkey")
+					mode := context.String("mode")
+					treeDepth := uint32(context.Uint("tree-depth"))
+					batchSize := uint32(context.Uint("batch-size"))
+					var system *prover.ProvingSystem
+					var err error
+
+					logging.Logger().Info().Msg("Importing zkey")
+
+					if mode == server.InsertionMode {
+						system, err = prover.ImportInsertionZkey(treeDepth, batchSize, zkey)
+					} else if mode == server.DeletionMode {
+						system, err = prover.ImportDeletionZkey(treeDepth, batchSize, zkey)
+					} else {
+						return fmt.Errorf("Invalid mode: %s", mode)
+					}
+					if err != nil {
+						return err
+					}
+
+					logging.Logger().Info().Msg("running self-test on the imported proving system")
+					if err := server.SelfTest(mode, system); err != nil {
+						return fmt.Errorf("imported proving system is not valid for %s mode: %w", mode, err)
+					}
+					file, err := os.Create(path)
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					written, err := system.WriteTo(file)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("proving system written to file")
+					return nil
+				},
+			},
+			{
+				Name:  "check-keys",
+				Usage: "checks the points and the dimensions of the keys, then proves and verifies a synthetic batch",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", EnvVars: []string{"MTB_MODE"}, DefaultText: "insertion"},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+				},
+				Action: func(context *cli.Context) error {
+					mode := context.String("mode")
+					if mode != server.DeletionMode && mode != server.InsertionMode {
+						return fmt.Errorf("invalid mode: %s", mode)
+					}
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("checking keys")
+					if err := ps.CheckKeys(); err != nil {
+						return err
+					}
+					logging.Logger().Info().Msg("running self-test")
+					if err := server.SelfTest(mode, ps); err != nil {
+						return fmt.Errorf("proving system is not valid for %s mode: %w", mode, err)
+					}
+					logging.Logger().Info().Msg("keys are valid")
+					return nil
+				},
+			},
+			{
+				Name:  "ceremony",
+				Usage: "phase-2 ceremony setting up the keys from the powers of tau of a phase-1 ceremony",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "init",
+						Usage: "starts a ceremony for the circuit from the powers of tau",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "output", Usage: "ceremony file", Required: true},
+							&cli.StringFlag{Name: "ptau", Usage: "powers of tau file, prepared for phase 2", Required: true},
+							&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", Required: true},
+							&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
+							&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+						},
+						Action: func(context *cli.Context) error {
+							ptau, err := os.Open(context.String("ptau"))
+							if err != nil {
+								return err
+							}
+							defer ptau.Close()
+							ceremony, err := prover.NewCeremony(
+								context.String("mode"),
+								uint32(context.Uint("tree-depth")),
+								uint32(context.Uint("batch-size")),
+								ptau,
+							)
+							if err != nil {
+								return err
+							}
+							file, err := os.Create(context.String("output"))
+							if err != nil {
+								return err
+							}
+							defer file.Close()
+							if _, err := ceremony.WriteTo(file); err != nil {
+								return err
+							}
+							logging.Logger().Info().Str("hash", hex.EncodeToString(ceremony.InitialHash[:])).Msg("ceremony initialized")
+							return nil
+						},
+					},
+					{
+						Name:  "contribute",
+						Usage: "adds a contribution to a ceremony and prints its hash",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "input", Usage: "ceremony file to contribute to", Required: true},
+							&cli.StringFlag{Name: "output", Usage: "ceremony file with the contribution", Required: true},
+							&cli.StringFlag{Name: "entropy", Usage: "entropy mixed with the randomness of the system", Required: false},
+						},
+						Action: func(context *cli.Context) error {
+							ceremony, err := prover.ReadCeremonyFromFile(context.String("input"))
+							if err != nil {
+								return err
+							}
+							contribution, err := ceremony.Contribute([]byte(context.String("entropy")))
+							if err != nil {
+								return err
+							}
+							file, err := os.Create(context.String("output"))
+							if err != nil {
+								return err
+							}
+							defer file.Close()
+							if _, err := ceremony.WriteTo(file); err != nil {
+								return err
+							}
+							logging.Logger().Info().Int("contribution", len(ceremony.Contributions)).Msg("contribution added")
+							fmt.Println(hex.EncodeToString(contribution.Hash[:]))
+							return nil
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "verifies a whole ceremony against the powers of tau it started from and prints its hashes",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "input", Usage: "ceremony file", Required: true},
+							&cli.StringFlag{Name: "ptau", Usage: "powers of tau file the ceremony started from", Required: true},
+						},
+						Action: func(context *cli.Context) error {
+							ceremony, err := prover.ReadCeremonyFromFile(context.String("input"))
+							if err != nil {
+								return err
+							}
+							ptau, err := os.Open(context.String("ptau"))
+							if err != nil {
+								return err
+							}
+							defer ptau.Close()
+							if err := ceremony.Verify(ptau); err != nil {
+								return err
+							}
+							fmt.Printf("initial %s\n", hex.EncodeToString(ceremony.InitialHash[:]))
+							for i, contribution := range ceremony.Contributions {
+								fmt.Printf("contribution %d %s\n", i+1, hex.EncodeToString(contribution.Hash[:]))
+							}
+							logging.Logger().Info().Msg("ceremony is valid")
+							return nil
+						},
+					},
+					{
+						Name:  "finalize",
+						Usage: "verifies a ceremony against the powers of tau it started from and writes its proving system",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "input", Usage: "ceremony file", Required: true},
+							&cli.StringFlag{Name: "ptau", Usage: "powers of tau file the ceremony started from", Required: true},
+							&cli.StringFlag{Name: "output", Usage: "proving system file", Required: true},
+						},
+						Action: func(context *cli.Context) error {
+							ceremony, err := prover.ReadCeremonyFromFile(context.String("input"))
+							if err != nil {
+								return err
+							}
+							ptau, err := os.Open(context.String("ptau"))
+							if err != nil {
+								return err
+							}
+							defer ptau.Close()
+							system, err := ceremony.Finalize(ptau)
+							if err != nil {
+								return err
+							}
+							logging.Logger().Info().Msg("running self-test on the proving system of the ceremony")
+							if err := server.SelfTest(ceremony.Mode, system); err != nil {
+								return fmt.Errorf("proving system of the ceremony is not valid for %s mode: %w", ceremony.Mode, err)
+							}
+							file, err := os.Create(context.String("output"))
+							if err != nil {
+								return err
+							}
+							defer file.Close()
+							written, err := system.WriteTo(file)
+							if err != nil {
+								return err
+							}
+							logging.Logger().Info().Int64("bytesWritten", written).Msg("proving system written to file")
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name: "export-solidity",
 				Flags: []cli.Flag{
@@ -207,57 +461,119 @@ func main() {
 					return err
 				},
 			},
+			{
+				Name:  "sign-keys",
+				Usage: "signs a proving system file, adding the signature to its detached signature file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "private-key", Usage: "PEM file of the ed25519 private key to sign with", Required: true},
+				},
+				Action: func(context *cli.Context) error {
+					keys := context.String("keys-file")
+					privateKey, err := prover.ReadSigningKey(context.String("private-key"))
+					if err != nil {
+						return err
+					}
+					digest, err := prover.SignKeysFile(keys, privateKey)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Str("digest", hex.EncodeToString(digest)).Str("signatureFile", prover.SignaturePath(keys)).Msg("keys file signed")
+					return nil
+				},
+			},
+			{
+				Name:  "inspect",
+				Usage: "describes a proving system file without loading its keys",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "human/json", Value: "human", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					format := context.String("format")
+					if format != "human" && format != "json" {
+						return fmt.Errorf("invalid format: %s", format)
+					}
+					info, err := prover.InspectKeysFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					if format == "json" {
+						r, err := json.Marshal(info)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(r))
+						return nil
+					}
+					mode := info.Mode
+					if mode == "" {
+						mode = "unknown"
+					}
+					fmt.Printf("mode:               %s\n", mode)
+					fmt.Printf("tree depth:         %d\n", info.TreeDepth)
+					fmt.Printf("batch size:         %d\n", info.BatchSize)
+					fmt.Printf("curve:              %s\n", info.Curve)
+					fmt.Printf("backend:            %s\n", info.Backend)
+					fmt.Printf("constraints:        %d\n", info.Constraints)
+					fmt.Printf("public wires:       %d\n", info.PublicWires)
+					fmt.Printf("secret wires:       %d\n", info.SecretWires)
+					fmt.Printf("internal wires:     %d\n", info.InternalWires)
+					fmt.Printf("sections:\n")
+					for _, section := range info.Sections {
+						fmt.Printf("    %-15s %12d bytes", section.Name, section.Size)
+						if section.Points > 0 {
+							fmt.Printf(" %10d points", section.Points)
+						}
+						fmt.Println()
+					}
+					fmt.Printf("verifying key hash: %s\n", info.VerifyingKeyHash)
+					fmt.Printf("sha256:             %s\n", info.SHA256)
+					return nil
+				},
+			},
 			{
 				Name: "gen-test-params",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", EnvVars: []string{"MTB_MODE"}, DefaultText: "insertion"},
 					&cli.UintFlag{Name: "tree-depth", Usage: "depth of the mock tree", Required: true},
 					&cli.UintFlag{Name: "batch-size", Usage: "batch size", Required: true},
+					&cli.StringFlag{Name: "output-format", Usage: "json/binary", Value: "json", Required: false},
 				},
 				Action: func(context *cli.Context) error {
 					mode := context.String("mode")
 
-					treeDepth := context.Int("tree-depth")
+					treeDepth := uint32(context.Uint("tree-depth"))
 					batchSize := uint32(context.Uint("batch-size"))
+					outputFormat := context.String("output-format")
+					if outputFormat != "json" && outputFormat != "binary" {
+						return fmt.Errorf("invalid output format: %s", outputFormat)
+					}
 					logging.Logger().Info().Msg("Generating test params for the insertion circuit")
 
 					var r []byte
 					var err error
 
 					if mode == server.InsertionMode {
-						params := prover.InsertionParameters{}
-						tree := NewTree(treeDepth)
-
-						params.StartIndex = 0
-						params.PreRoot = tree.Root()
-						params.IdComms = make([]big.Int, batchSize)
-						params.MerkleProofs = make([][]big.Int, batchSize)
-						for i := 0; i < int(batchSize); i++ {
-							params.IdComms[i] = *new(big.Int).SetUint64(uint64(i + 1))
-							params.MerkleProofs[i] = tree.Update(i, params.IdComms[i])
-						}
-						params.PostRoot = tree.Root()
-						params.ComputeInputHashInsertion()
-						r, err = json.Marshal(&params)
+						var params *prover.InsertionParameters
+						params, err = prover.GenerateInsertionTestParams(treeDepth, batchSize)
+						if err != nil {
+							return err
+						}
+						if outputFormat == "binary" {
+							return params.WriteBinary(os.Stdout)
+						}
+						r, err = json.Marshal(params)
 					} else if mode == server.DeletionMode {
-						params := prover.DeletionParameters{}
-						tree := NewTree(treeDepth)
-
-						params.DeletionIndices = make([]uint32, batchSize)
-						params.IdComms = make([]big.Int, batchSize)
-						params.MerkleProofs = make([][]big.Int, batchSize)
-						for i := 0; i < int(batchSize*2); i++ {
-							tree.Update(i, *new(big.Int).SetUint64(uint64(i + 1)))
-						}
-						params.PreRoot = tree.Root()
-						for i := 0; i < int(batchSize); i++ {
-							params.DeletionIndices[i] = uint32(2 * i)
-							params.IdComms[i] = *new(big.Int).SetUint64(uint64(2*i + 1))
-							params.MerkleProofs[i] = tree.Update(2*i, *big.NewInt(0))
-						}
-						params.PostRoot = tree.Root()
-						params.ComputeInputHashDeletion()
-						r, err = json.Marshal(&params)
+						var params *prover.DeletionParameters
+						params, err = prover.GenerateDeletionTestParams(treeDepth, batchSize)
+						if err != nil {
+							return err
+						}
+						if outputFormat == "binary" {
+							return params.WriteBinary(os.Stdout)
+						}
+						r, err = json.Marshal(params)
 					} else {
 						return fmt.Errorf("Invalid mode: %s", mode)
 					}
@@ -278,6 +594,18 @@ func main() {
 					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
 					&cli.StringFlag{Name: "prover-address", Usage: "address for the prover server", Value: "localhost:3001", Required: false},
 					&cli.StringFlag{Name: "metrics-address", Usage: "address for the metrics server", Value: "localhost:9998", Required: false},
+					&cli.StringFlag{Name: "job-store-path", Usage: "directory for storing the results of proving jobs (kept in memory if not provided)", Required: false},
+					&cli.DurationFlag{Name: "job-ttl", Usage: "how long the results of finished proving jobs are kept, unlimited if zero", Value: server.DefaultJobTTL, Required: false},
+					&cli.IntFlag{Name: "max-finished-jobs", Usage: "number of finished proving jobs kept, the oldest being removed first, unlimited if zero", Value: 0, Required: false},
+					&cli.IntFlag{Name: "prover-workers", Usage: "number of proofs generated concurrently", Value: server.DefaultProverWorkers, Required: false},
+					&cli.IntFlag{Name: "queue-depth", Usage: "number of proving requests waiting for a free worker before new ones are rejected", Value: server.DefaultQueueDepth, Required: false},
+					&cli.BoolFlag{Name: "watch-keys-file", Usage: "reload the keys file when it changes on disk", Required: false},
+					&cli.DurationFlag{Name: "drain-timeout", Usage: "how long to wait for the proofs in flight to finish when shutting down", Value: server.DefaultDrainTimeout, Required: false},
+					&cli.DurationFlag{Name: "max-proving-time", Usage: "longest time a proving request may take, including queueing, unlimited if zero", Value: 0, Required: false},
+					&cli.StringFlag{Name: "grpc-address", Usage: "address for the grpc server, which is not started if empty", Value: "", Required: false},
+					&cli.BoolFlag{Name: "check-keys", Usage: "check the keys and prove a synthetic batch before serving, and on every reload", Required: false},
+					&cli.BoolFlag{Name: "self-test", Usage: "prove and verify a synthetic batch before serving", Required: false},
+					&cli.StringSliceFlag{Name: "trusted-keys", Usage: "PEM files of the ed25519 public keys trusted to sign the keys file, whose signature is then required", EnvVars: []string{"MTB_TRUSTED_KEYS"}, Required: false},
 				},
 				Action: func(context *cli.Context) error {
 					if context.Bool("json-logging") {
@@ -289,27 +617,110 @@ func main() {
 					if mode != server.DeletionMode && mode != server.InsertionMode {
 						return fmt.Errorf("invalid mode: %s", mode)
 					}
-
-					logging.Logger().Info().Msg("Reading proving system from file")
-					ps, err := prover.ReadSystemFromFile(keys)
-					if err != nil {
-						return err
+					var trust *prover.KeysTrust
+					if trusted := context.StringSlice("trusted-keys"); len(trusted) > 0 {
+						var err error
+						trust, err = prover.ReadTrustedKeys(trusted)
+						if err != nil {
+							return err
+						}
 					}
-					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+
 					config := server.Config{
 						ProverAddress:  context.String("prover-address"),
 						MetricsAddress: context.String("metrics-address"),
 						Mode:           mode,
+						ProverWorkers:  context.Int("prover-workers"),
+						QueueDepth:     context.Int("queue-depth"),
+						DrainTimeout:   context.Duration("drain-timeout"),
+						MaxProvingTime: context.Duration("max-proving-time"),
+						RestartPolicy:  server.DefaultRestartPolicy,
+						GrpcAddress:    context.String("grpc-address"),
+						JobRetention: server.JobRetention{
+							TTL:         context.Duration("job-ttl"),
+							MaxFinished: context.Int("max-finished-jobs"),
+						},
+					}
+					if jobStorePath := context.String("job-store-path"); jobStorePath != "" {
+						var err error
+						config.JobStore, err = server.NewDiskJobStore(jobStorePath, config.JobRetention)
+						if err != nil {
+							return err
+						}
 					}
-					instance := server.Run(&config, ps)
-					sigint := make(chan os.Signal, 1)
-					signal.Notify(sigint, os.Interrupt)
-					<-sigint
-					logging.Logger().Info().Msg("Received sigint, shutting down")
+
+					// The servers are started before the keys are read, so that the
+					// process can be probed while the (possibly very large) file loads.
+					system := server.NewSystemHolder()
+					instance := server.RunWithSystem(&config, system)
+					loadErr := make(chan error, 1)
+					go func() {
+						logging.Logger().Info().Msg("Reading proving system from file")
+						ps, err := trust.ReadSystemFromFile(keys)
+						if err != nil {
+							loadErr <- err
+							return
+						}
+						logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+						// A rejected proving system leaves the server running but not
+						// ready, so that the failure shows on the probes and metrics.
+						if context.Bool("check-keys") {
+							logging.Logger().Info().Msg("Checking keys")
+							if err := ps.CheckKeys(); err != nil {
+								logging.Logger().Error().Err(err).Msg("Invalid keys, the server will not become ready")
+								system.Reject(err)
+								return
+							}
+						}
+						if context.Bool("self-test") || context.Bool("check-keys") {
+							logging.Logger().Info().Msg("Running self-test")
+							if err := server.SelfTest(mode, ps); err != nil {
+								system.Reject(fmt.Errorf("proving system is not valid for %s mode: %w", mode, err))
+								return
+							}
+						}
+						system.Set(ps)
+					}()
+
+					// SIGHUP reloads the keys file, swapping it in for new requests.
+					reloader := server.NewKeysReloader(mode, keys, system, context.Bool("check-keys"), trust)
+					if context.Bool("watch-keys-file") {
+						instance = server.CombineJobs(instance, reloader.Watch(server.DefaultKeysFilePollInterval))
+					}
+					sighup := make(chan os.Signal, 1)
+					signal.Notify(sighup, syscall.SIGHUP)
+					go func() {
+						for range sighup {
+							logging.Logger().Info().Msg("Received sighup, reloading proving system")
+							err := reloader.Reload()
+							if err != nil {
+								logging.Logger().Error().Err(err).Msg("Failed to reload proving system, keeping the current one")
+							}
+						}
+					}()
+
+					stopSignal := make(chan os.Signal, 1)
+					signal.Notify(stopSignal, os.Interrupt, syscall.SIGTERM)
+					var err error
+					select {
+					case sig := <-stopSignal:
+						logging.Logger().Info().Str("signal", sig.String()).Msg("Received signal, shutting down")
+					case err = <-loadErr:
+						logging.Logger().Error().Err(err).Msg("Failed to read proving system, shutting down")
+					case <-instance.Failed():
+						err = instance.Err()
+						logging.Logger().Error().Err(err).Msg("Server failed, shutting down")
+					}
+					signal.Stop(sighup)
 					instance.RequestStop()
 					logging.Logger().Info().Msg("Waiting for server to close")
-					instance.AwaitStop()
-					return nil
+					stopErr := instance.AwaitStop()
+					if errors.Is(stopErr, server.ErrDrainTimeout) {
+						logging.Logger().Warn().Err(stopErr).Msg("Proofs in flight were abandoned")
+					} else if stopErr != nil {
+						logging.Logger().Error().Err(stopErr).Msg("Error when shutting down")
+					}
+					return err
 				},
 			},
 			{
@@ -317,23 +728,75 @@ func main() {
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", EnvVars: []string{"MTB_MODE"}, DefaultText: "insertion"},
 					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "input-format", Usage: "json/binary", Value: "json", Required: false},
+					&cli.StringFlag{Name: "witness", Usage: "witness file written by the witness command, read instead of params from stdin", Required: false},
+					&cli.StringSliceFlag{Name: "trusted-keys", Usage: "PEM files of the ed25519 public keys trusted to sign the keys file, whose signature is then required", EnvVars: []string{"MTB_TRUSTED_KEYS"}, Required: false},
 				},
 				Action: func(context *cli.Context) error {
 					mode := context.String("mode")
+					inputFormat := context.String("input-format")
+					if inputFormat != "json" && inputFormat != "binary" {
+						return fmt.Errorf("invalid input format: %s", inputFormat)
+					}
+					var trust *prover.KeysTrust
+					if trusted := context.StringSlice("trusted-keys"); len(trusted) > 0 {
+						var err error
+						trust, err = prover.ReadTrustedKeys(trusted)
+						if err != nil {
+							return err
+						}
+					}
 
 					keys := context.String("keys-file")
-					ps, err := prover.ReadSystemFromFile(keys)
+					ps, err := trust.ReadSystemFromFile(keys)
 					if err != nil {
 						return err
 					}
 					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+
+					var proof *prover.Proof
+					if path := context.String("witness"); path != "" {
+						file, err := os.Open(path)
+						if err != nil {
+							return err
+						}
+						defer file.Close()
+						witness, err := ps.ReadWitnessFile(file, mode)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Msg("witness read successfully")
+						proof, err = ps.ReplayWitness(witness)
+						if err != nil {
+							return err
+						}
+						r, _ := json.Marshal(&proof)
+						fmt.Println(string(r))
+						return nil
+					}
 					logging.Logger().Info().Msg("reading params from stdin")
+					if inputFormat == "binary" {
+						witness, err := ps.ReadWitness(os.Stdin)
+						if err != nil {
+							return err
+						}
+						if witness.Mode() != mode {
+							return fmt.Errorf("expected %s parameters, got %s parameters", mode, witness.Mode())
+						}
+						logging.Logger().Info().Msg("params read successfully")
+						proof, err = ps.ProveWitness(witness)
+						if err != nil {
+							return err
+						}
+						r, _ := json.Marshal(&proof)
+						fmt.Println(string(r))
+						return nil
+					}
 					bytes, err := io.ReadAll(os.Stdin)
 					if err != nil {
 						return err
 					}
 
-					var proof *prover.Proof
 					if mode == server.InsertionMode {
 						var params prover.InsertionParameters
 						err = json.Unmarshal(bytes, &params)
@@ -362,6 +825,68 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name: "witness",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", EnvVars: []string{"MTB_MODE"}, DefaultText: "insertion"},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "gnark/wtns", Value: prover.WitnessFormatGnark, Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					mode := context.String("mode")
+					format := context.String("format")
+					if format != prover.WitnessFormatGnark && format != prover.WitnessFormatWtns {
+						return fmt.Errorf("invalid witness format: %s", format)
+					}
+
+					keys := context.String("keys-file")
+					ps, err := prover.ReadSystemFromFile(keys)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+					logging.Logger().Info().Msg("reading params from stdin")
+					bytes, err := io.ReadAll(os.Stdin)
+					if err != nil {
+						return err
+					}
+
+					var witness *prover.Witness
+					if mode == server.InsertionMode {
+						var params prover.InsertionParameters
+						err = json.Unmarshal(bytes, &params)
+						if err != nil {
+							return err
+						}
+						witness, err = ps.InsertionWitness(&params)
+					} else if mode == server.DeletionMode {
+						var params prover.DeletionParameters
+						err = json.Unmarshal(bytes, &params)
+						if err != nil {
+							return err
+						}
+						witness, err = ps.DeletionWitness(&params)
+					} else {
+						return fmt.Errorf("Invalid mode: %s", mode)
+					}
+					if err != nil {
+						return err
+					}
+
+					file, err := os.Create(context.String("output"))
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					err = ps.WriteWitness(file, witness, format)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Str("format", format).Msg("witness written to file")
+					return nil
+				},
+			},
 			{
 				Name: "verify",
 				Flags: []cli.Flag{
@@ -374,9 +899,9 @@ func main() {
 
 					keys := context.String("keys-file")
 					var inputHash big.Int
-					_, ok := inputHash.SetString(context.String("input-hash"), 0)
-					if !ok {
-						return fmt.Errorf("invalid number: %s", context.String("input-hash"))
+					err := prover.ParseInputHash(&inputHash, context.String("input-hash"), "input-hash")
+					if err != nil {
+						return err
 					}
 					ps, err := prover.ReadSystemFromFile(keys)
 					if err != nil {
@@ -410,6 +935,43 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name: "encode-inputs",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "mode", Usage: "insertion/deletion", EnvVars: []string{"MTB_MODE"}, DefaultText: "insertion"},
+				},
+				Action: func(context *cli.Context) error {
+					mode := context.String("mode")
+
+					logging.Logger().Info().Msg("reading params from stdin")
+					bytes, err := io.ReadAll(os.Stdin)
+					if err != nil {
+						return err
+					}
+
+					var preimage []byte
+					if mode == server.InsertionMode {
+						var params prover.InsertionParameters
+						err = json.Unmarshal(bytes, &params)
+						if err != nil {
+							return err
+						}
+						preimage = params.InputHashPreimage()
+					} else if mode == server.DeletionMode {
+						var params prover.DeletionParameters
+						err = json.Unmarshal(bytes, &params)
+						if err != nil {
+							return err
+						}
+						preimage = params.InputHashPreimage()
+					} else {
+						return fmt.Errorf("Invalid mode: %s", mode)
+					}
+
+					fmt.Println("0x" + hex.EncodeToString(preimage))
+					return nil
+				},
+			},
 			{
 				Name: "extract-circuit",
 				Flags: []cli.Flag{
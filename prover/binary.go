@@ -0,0 +1,288 @@
+package prover
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// The binary encoding of the parameters of a batch is a header followed by
+// every value as 32 big-endian bytes, in the order of the inputs of the
+// circuit. Like in the JSON encoding, values must be below the modulus of the
+// scalar field, except for the input hash, which is reduced:
+//
+//	header:    "MTB", version 1, mode (0 insertion, 1 deletion),
+//	           tree depth and batch size as big-endian uint32
+//	insertion: InputHash, StartIndex, PreRoot, PostRoot,
+//	           IdComms[batch size], MerkleProofs[batch size][tree depth]
+//	deletion:  InputHash, DeletionIndices[batch size], PreRoot, PostRoot,
+//	           IdComms[batch size], MerkleProofs[batch size][tree depth]
+//
+// This lets the values be decoded straight into a witness, without going
+// through hex strings and big integers.
+var binaryMagic = [4]byte{'M', 'T', 'B', 1}
+
+const (
+	binaryInsertionMode byte = 0
+	binaryDeletionMode  byte = 1
+)
+
+// modulusBytes is the modulus of the scalar field in the binary encoding.
+var modulusBytes = func() (b [fpSize]byte) {
+	fr.Modulus().FillBytes(b[:])
+	return b
+}()
+
+type binaryHeader struct {
+	Magic     [4]byte
+	Mode      byte
+	TreeDepth uint32
+	BatchSize uint32
+}
+
+type binaryWriter struct {
+	writer *bufio.Writer
+	buf    [fpSize]byte
+	err    error
+}
+
+func (w *binaryWriter) element(value *big.Int) {
+	if w.err != nil {
+		return
+	}
+	if value.Sign() < 0 || value.BitLen() > 8*fpSize {
+		w.err = fmt.Errorf("value does not fit in %d bytes: %s", fpSize, toHex(value))
+		return
+	}
+	value.FillBytes(w.buf[:])
+	_, w.err = w.writer.Write(w.buf[:])
+}
+
+func (w *binaryWriter) index(value uint32) {
+	w.element(new(big.Int).SetUint64(uint64(value)))
+}
+
+func (w *binaryWriter) elements(values []big.Int) {
+	for i := range values {
+		w.element(&values[i])
+	}
+}
+
+func writeBinary(writer io.Writer, mode byte, treeDepth uint32, batchSize uint32, body func(w *binaryWriter)) error {
+	w := &binaryWriter{writer: bufio.NewWriter(writer)}
+	w.err = binary.Write(w.writer, binary.BigEndian, &binaryHeader{binaryMagic, mode, treeDepth, batchSize})
+	body(w)
+	if w.err != nil {
+		return w.err
+	}
+	return w.writer.Flush()
+}
+
+// WriteBinary writes the parameters in the binary encoding, which always holds
+// every Merkle proof, deriving them from the start path if needed.
+func (p *InsertionParameters) WriteBinary(writer io.Writer) error {
+	treeDepth := len(p.StartPath)
+	if len(p.MerkleProofs) > 0 {
+		treeDepth = len(p.MerkleProofs[0])
+	}
+	if err := p.ValidateShape(uint32(treeDepth), uint32(len(p.IdComms))); err != nil {
+		return err
+	}
+	merkleProofs, err := p.merkleProofs()
+	if err != nil {
+		return err
+	}
+	return writeBinary(writer, binaryInsertionMode, uint32(treeDepth), uint32(len(p.IdComms)), func(w *binaryWriter) {
+		w.element(&p.InputHash)
+		w.index(p.StartIndex)
+		w.element(&p.PreRoot)
+		w.element(&p.PostRoot)
+		w.elements(p.IdComms)
+		for _, proof := range merkleProofs {
+			w.elements(proof)
+		}
+	})
+}
+
+// WriteBinary writes the parameters in the binary encoding.
+func (p *DeletionParameters) WriteBinary(writer io.Writer) error {
+	treeDepth := 0
+	if len(p.MerkleProofs) > 0 {
+		treeDepth = len(p.MerkleProofs[0])
+	}
+	if err := p.ValidateShape(uint32(treeDepth), uint32(len(p.IdComms))); err != nil {
+		return err
+	}
+	return writeBinary(writer, binaryDeletionMode, uint32(treeDepth), uint32(len(p.IdComms)), func(w *binaryWriter) {
+		w.element(&p.InputHash)
+		for _, index := range p.DeletionIndices {
+			w.index(index)
+		}
+		w.element(&p.PreRoot)
+		w.element(&p.PostRoot)
+		w.elements(p.IdComms)
+		for _, proof := range p.MerkleProofs {
+			w.elements(proof)
+		}
+	})
+}
+
+// Witness is the full assignment of a circuit, ready to be proven.
+type Witness struct {
+	mode      string
+	treeDepth uint32
+	batchSize uint32
+	witness   witness.Witness
+}
+
+// Mode returns insertion or deletion, depending on the circuit the witness is
+// for.
+func (w *Witness) Mode() string {
+	return w.mode
+}
+
+// ReadWitness decodes parameters in the binary encoding from r straight into a
+// witness, checking that they fit the proving system.
+func (ps *ProvingSystem) ReadWitness(r io.Reader) (*Witness, error) {
+	start := time.Now()
+	reader := bufio.NewReader(r)
+	var header binaryHeader
+	err := binary.Read(reader, binary.BigEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Magic != binaryMagic {
+		return nil, fmt.Errorf("not a binary encoding of batch parameters")
+	}
+	var mode string
+	switch header.Mode {
+	case binaryInsertionMode:
+		mode = insertionMode
+	case binaryDeletionMode:
+		mode = deletionMode
+	default:
+		return nil, fmt.Errorf("unknown mode: %d", header.Mode)
+	}
+	if header.TreeDepth != ps.TreeDepth || header.BatchSize != ps.BatchSize {
+		return nil, fmt.Errorf(
+			"parameters for depth %d and batch size %d do not fit the proving system for depth %d and batch size %d",
+			header.TreeDepth, header.BatchSize, ps.TreeDepth, ps.BatchSize,
+		)
+	}
+
+	// InputHash is the only public input, the rest follows in order. The
+	// indices of a deletion take the place of the start index of an insertion.
+	nbIndices := 1
+	if mode == deletionMode {
+		nbIndices = int(ps.BatchSize)
+	}
+	nbSecret := ps.nbSecret(mode)
+
+	values := make(chan any)
+	decodeErr := make(chan error, 1)
+	go func() {
+		defer close(values)
+		var buf [fpSize]byte
+		for i := 0; i < 1+nbSecret; i++ {
+			_, err := io.ReadFull(reader, buf[:])
+			if err != nil {
+				decodeErr <- fmt.Errorf("reading value %d: %w", i, err)
+				return
+			}
+			isIndex := i >= 1 && i <= nbIndices
+			if isIndex && !isUint32(buf[:]) {
+				decodeErr <- fmt.Errorf("value %d is not a valid index", i)
+				return
+			}
+			if i >= 1 && bytes.Compare(buf[:], modulusBytes[:]) >= 0 {
+				decodeErr <- &FieldElementError{Kind: NumberOutOfRange, Path: fmt.Sprintf("value %d", i), Value: "0x" + hex.EncodeToString(buf[:])}
+				return
+			}
+			var element fr.Element
+			element.SetBytes(buf[:])
+			values <- element
+		}
+		decodeErr <- nil
+	}()
+
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		for range values {
+		}
+		return nil, err
+	}
+	fillErr := w.Fill(1, nbSecret, values)
+	// Drain the values in case Fill stopped early, so the decoder can finish.
+	for range values {
+	}
+	if err := <-decodeErr; err != nil {
+		return nil, err
+	}
+	if fillErr != nil {
+		return nil, fillErr
+	}
+	observeDuration(witnessDuration, mode, ps.BatchSize, start)
+	return &Witness{mode: mode, treeDepth: header.TreeDepth, batchSize: header.BatchSize, witness: w}, nil
+}
+
+func isUint32(value []byte) bool {
+	for _, b := range value[:len(value)-4] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ProveWitness generates a proof for a witness read with ReadWitness, or built
+// with InsertionWitness or DeletionWitness. Like the parameters given to
+// ProveInsertion and ProveDeletion, the inputs are validated first, returning
+// a ValidationError for the first failing check.
+func (ps *ProvingSystem) ProveWitness(w *Witness) (*Proof, error) {
+	return ps.ProveWitnessContext(context.Background(), w)
+}
+
+// ProveWitnessContext is like ProveWitness, but gives up on the proof once ctx
+// is done, returning the error of the context.
+func (ps *ProvingSystem) ProveWitnessContext(ctx context.Context, w *Witness) (*Proof, error) {
+	if err := ps.checkWitness(w); err != nil {
+		return nil, err
+	}
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	return ps.proveInputs(ctx, w)
+}
+
+// ReplayWitness generates a proof for a witness as it is, without validating
+// its inputs, so that a witness captured with WriteWitness and read with
+// ReadWitnessFile fails in the constraint solver exactly like the batch it
+// was captured from.
+func (ps *ProvingSystem) ReplayWitness(w *Witness) (*Proof, error) {
+	if err := ps.checkWitness(w); err != nil {
+		return nil, err
+	}
+	return ps.proveInputs(context.Background(), w)
+}
+
+func (ps *ProvingSystem) proveInputs(ctx context.Context, w *Witness) (*Proof, error) {
+	proof, err := ps.proveWitness(ctx, w.mode, w.witness)
+	if err != nil {
+		return nil, err
+	}
+	// The input hash is the public input, as reduced in the witness.
+	var inputHash big.Int
+	w.witness.Vector().(fr.Vector)[0].BigInt(&inputHash)
+	proof.InputHash = &inputHash
+	return proof, nil
+}
@@ -0,0 +1,48 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+func TestReadWitnessFieldElements(t *testing.T) {
+	params := DeletionParameters{
+		DeletionIndices: []uint32{1, 2},
+		IdComms:         make([]big.Int, 2),
+		MerkleProofs:    [][]big.Int{make([]big.Int, 3), make([]big.Int, 3)},
+	}
+	var buf bytes.Buffer
+	if err := params.WriteBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ps := ProvingSystem{TreeDepth: 3, BatchSize: 2}
+	if _, err := ps.ReadWitness(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values follow the header of 13 bytes, the input hash first.
+	value := func(data []byte, i int) []byte {
+		offset := 13 + i*fpSize
+		return data[offset : offset+fpSize]
+	}
+	inputHash := bytes.Clone(buf.Bytes())
+	copy(value(inputHash, 0), bytes.Repeat([]byte{0xff}, fpSize))
+	if _, err := ps.ReadWitness(bytes.NewReader(inputHash)); err != nil {
+		t.Fatalf("input hash above the modulus not reduced: %s", err)
+	}
+
+	for _, i := range []int{3, 5, 9} {
+		data := bytes.Clone(buf.Bytes())
+		fr.Modulus().FillBytes(value(data, i))
+		_, err := ps.ReadWitness(bytes.NewReader(data))
+		var fieldErr *FieldElementError
+		if !errors.As(err, &fieldErr) || fieldErr.Kind != NumberOutOfRange || fieldErr.Path != fmt.Sprintf("value %d", i) {
+			t.Fatalf("unexpected error for value %d at the modulus: %v", i, err)
+		}
+	}
+}
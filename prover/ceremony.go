@@ -0,0 +1,624 @@
+package prover
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+
+	"worldcoin/gnark-mbu/logging"
+)
+
+// A phase-2 ceremony sets up the keys of a circuit from the powers of tau of
+// a phase-1 ceremony, following Bowe, Gabizon and Miers, "Scalable Multi-party
+// Computation for zk-SNARK Parameters in the Random Beacon Model". The powers
+// of tau fix every point of the keys but δ and the points divided by δ. The
+// ceremony starts from δ = 1, and every contribution multiplies δ by a secret
+// x, dividing the points by x. The keys are safe as long as one of the
+// contributors did not keep their x.
+//
+// Every contribution is recorded with a proof that its contributor knew x:
+// [s]1 and [s·x]1 for a random s, and [r·x]2 for r hashed to G2 from the hash
+// of the previous contribution, [s]1 and [s·x]1. The hash of a contribution
+// chains the hash of the previous one, or of the initial keys for the first
+// one, with the new δ and the proof, so that contributors can attest to it.
+//
+// A ceremony file holds the tree depth, the batch size and the mode as
+// big-endian uint32, the length of the mode coming before it, the hash of the
+// initial keys, the current keys and the contributions. Points are written in
+// the raw encoding of gnark.
+type Ceremony struct {
+	Mode          string
+	TreeDepth     uint32
+	BatchSize     uint32
+	InitialHash   [sha256.Size]byte
+	Contributions []Contribution
+	keys          setupKeys
+}
+
+// Contribution is the record of a contribution to a ceremony.
+type Contribution struct {
+	Delta1 bn254.G1Affine
+	Delta2 bn254.G2Affine
+	S      bn254.G1Affine
+	SX     bn254.G1Affine
+	RX     bn254.G2Affine
+	Hash   [sha256.Size]byte
+}
+
+var contributionDST = []byte("MTB_PHASE2_CONTRIBUTION")
+
+func buildR1CS(mode string, treeDepth uint32, batchSize uint32) (*cs_bn254.R1CS, error) {
+	var ccs constraint.ConstraintSystem
+	var err error
+	switch mode {
+	case insertionMode:
+		ccs, err = BuildR1CSInsertion(treeDepth, batchSize)
+	case deletionMode:
+		ccs, err = BuildR1CSDeletion(treeDepth, batchSize)
+	default:
+		return nil, fmt.Errorf("invalid mode: %s", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r1cs, ok := ccs.(*cs_bn254.R1CS)
+	if !ok {
+		return nil, fmt.Errorf("unsupported constraint system: %T", ccs)
+	}
+	return r1cs, nil
+}
+
+// NewCeremony starts the phase-2 ceremony of the circuit of mode from the
+// powers of tau of a ptau file.
+func NewCeremony(mode string, treeDepth uint32, batchSize uint32, ptau io.ReadSeeker) (*Ceremony, error) {
+	r1cs, err := buildR1CS(mode, treeDepth, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := initialKeys(r1cs, ptau)
+	if err != nil {
+		return nil, err
+	}
+	return &Ceremony{Mode: mode, TreeDepth: treeDepth, BatchSize: batchSize, InitialHash: keys.hash(), keys: *keys}, nil
+}
+
+// setupTerm is a term of a wire in one of the matrices of an R1CS.
+type setupTerm struct {
+	constraint uint32
+	coeff      uint32
+}
+
+// wireTerms returns the terms of every wire in the L, R and O matrices of the
+// R1CS, sorted by coefficient.
+func wireTerms(r1cs *cs_bn254.R1CS, nbWires int) [3][][]setupTerm {
+	var terms [3][][]setupTerm
+	for matrix := range terms {
+		terms[matrix] = make([][]setupTerm, nbWires)
+	}
+	for i, r1c := range r1cs.Constraints {
+		for matrix, expression := range []constraint.LinearExpression{r1c.L, r1c.R, r1c.O} {
+			for _, term := range expression {
+				if term.CoeffID() == constraint.CoeffIdZero {
+					continue
+				}
+				wire := term.VID
+				if term.IsConstant() {
+					wire = 0
+				}
+				terms[matrix][wire] = append(terms[matrix][wire], setupTerm{uint32(i), term.CID})
+			}
+		}
+	}
+	for matrix := range terms {
+		for _, wire := range terms[matrix] {
+			sort.Slice(wire, func(i, j int) bool { return wire[i].coeff < wire[j].coeff })
+		}
+	}
+	return terms
+}
+
+// combineG1 returns the sum of the terms times the points of their
+// constraints. The points of the terms with the same coefficient are added up
+// before being multiplied, circuits having few different coefficients.
+func combineG1(terms []setupTerm, points []bn254.G1Affine, coefficients []fr.Element) bn254.G1Jac {
+	var result, group bn254.G1Jac
+	var scalar big.Int
+	for i := 0; i < len(terms); {
+		coeff := terms[i].coeff
+		group = bn254.G1Jac{}
+		for ; i < len(terms) && terms[i].coeff == coeff; i++ {
+			group.AddMixed(&points[terms[i].constraint])
+		}
+		switch coeff {
+		case constraint.CoeffIdOne:
+		case constraint.CoeffIdMinusOne:
+			group.Neg(&group)
+		default:
+			group.ScalarMultiplication(&group, coefficients[coeff].BigInt(&scalar))
+		}
+		result.AddAssign(&group)
+	}
+	return result
+}
+
+// combineG2 is combineG1 in G2.
+func combineG2(terms []setupTerm, points []bn254.G2Affine, coefficients []fr.Element) bn254.G2Jac {
+	var result, group bn254.G2Jac
+	var scalar big.Int
+	for i := 0; i < len(terms); {
+		coeff := terms[i].coeff
+		group = bn254.G2Jac{}
+		for ; i < len(terms) && terms[i].coeff == coeff; i++ {
+			group.AddMixed(&points[terms[i].constraint])
+		}
+		switch coeff {
+		case constraint.CoeffIdOne:
+		case constraint.CoeffIdMinusOne:
+			group.Neg(&group)
+		default:
+			group.ScalarMultiplication(&group, coefficients[coeff].BigInt(&scalar))
+		}
+		result.AddAssign(&group)
+	}
+	return result
+}
+
+// initialKeys computes the keys of the R1CS from the powers of tau, with
+// γ = δ = 1. A_i(τ), B_i(τ) and C_i(τ) are the sums of the Lagrange
+// polynomials of the constraints at τ, weighted by the coefficients of wire i
+// in the constraints.
+func initialKeys(r1cs *cs_bn254.R1CS, ptau io.ReadSeeker) (*setupKeys, error) {
+	domain := fft.NewDomain(uint64(len(r1cs.Constraints)))
+	n := int(domain.Cardinality)
+	logging.Logger().Info().Int("domainSize", n).Msg("reading powers of tau")
+	powers, err := readPhase1(ptau, n)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Logger().Info().Msg("computing initial keys")
+	_, _, g1, g2 := bn254.Generators()
+	keys := setupKeys{
+		alpha1: powers.alpha1,
+		beta1:  powers.beta1,
+		delta1: g1,
+		beta2:  powers.beta2,
+		gamma2: g2,
+		delta2: g2,
+	}
+	nbPublic := r1cs.GetNbPublicVariables()
+	nbWires := nbPublic + r1cs.GetNbSecretVariables() + r1cs.GetNbInternalVariables()
+	terms := wireTerms(r1cs, nbWires)
+	keys.a = make([]bn254.G1Affine, nbWires)
+	keys.b1 = make([]bn254.G1Affine, nbWires)
+	keys.b2 = make([]bn254.G2Affine, nbWires)
+	k := make([]bn254.G1Affine, nbWires)
+	parallelize(nbWires, func(start int, end int) {
+		for wire := start; wire < end; wire++ {
+			l, r, o := terms[0][wire], terms[1][wire], terms[2][wire]
+			a := combineG1(l, powers.lagrange1, r1cs.Coefficients)
+			keys.a[wire].FromJacobian(&a)
+			b1 := combineG1(r, powers.lagrange1, r1cs.Coefficients)
+			keys.b1[wire].FromJacobian(&b1)
+			b2 := combineG2(r, powers.lagrange2, r1cs.Coefficients)
+			keys.b2[wire].FromJacobian(&b2)
+			sum := combineG1(l, powers.betaLagrange1, r1cs.Coefficients)
+			alphaB := combineG1(r, powers.alphaLagrange1, r1cs.Coefficients)
+			c := combineG1(o, powers.lagrange1, r1cs.Coefficients)
+			sum.AddAssign(&alphaB).AddAssign(&c)
+			k[wire].FromJacobian(&sum)
+		}
+	})
+	keys.ic = k[:nbPublic]
+	keys.k = k[nbPublic:]
+
+	// τ^j·Z(τ) = τ^(j+n) - τ^j
+	keys.z = make([]bn254.G1Affine, n)
+	parallelize(n, func(start int, end int) {
+		var point bn254.G1Jac
+		var power bn254.G1Affine
+		for j := start; j < end; j++ {
+			point.FromAffine(&powers.tau1[j+n])
+			power.Neg(&powers.tau1[j])
+			point.AddMixed(&power)
+			keys.z[j].FromJacobian(&point)
+		}
+	})
+	bitReverse(keys.z)
+	return &keys, nil
+}
+
+// encode writes the keys with enc, in the order of setupKeys.
+func (keys *setupKeys) encode(enc *bn254.Encoder) error {
+	for _, v := range []interface{}{
+		&keys.alpha1, &keys.beta1, &keys.delta1, &keys.beta2, &keys.gamma2, &keys.delta2,
+		keys.a, keys.b1, keys.b2, keys.k, keys.ic, keys.z,
+	} {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (keys *setupKeys) decode(dec *bn254.Decoder) error {
+	for _, v := range []interface{}{
+		&keys.alpha1, &keys.beta1, &keys.delta1, &keys.beta2, &keys.gamma2, &keys.delta2,
+		&keys.a, &keys.b1, &keys.b2, &keys.k, &keys.ic, &keys.z,
+	} {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (keys *setupKeys) hash() [sha256.Size]byte {
+	hash := sha256.New()
+	buffered := bufio.NewWriter(hash)
+	// Writing to a hash does not fail.
+	_ = keys.encode(bn254.NewEncoder(buffered, bn254.RawEncoding()))
+	_ = buffered.Flush()
+	var result [sha256.Size]byte
+	copy(result[:], hash.Sum(nil))
+	return result
+}
+
+// challenge returns the point r of the proof of knowledge of a contribution.
+func (c *Contribution) challenge(previousHash [sha256.Size]byte) (bn254.G2Affine, error) {
+	var message bytes.Buffer
+	message.Write(previousHash[:])
+	enc := bn254.NewEncoder(&message, bn254.RawEncoding())
+	if err := enc.Encode(&c.S); err != nil {
+		return bn254.G2Affine{}, err
+	}
+	if err := enc.Encode(&c.SX); err != nil {
+		return bn254.G2Affine{}, err
+	}
+	return bn254.HashToG2(message.Bytes(), contributionDST)
+}
+
+func (c *Contribution) hash(previousHash [sha256.Size]byte) [sha256.Size]byte {
+	hash := sha256.New()
+	hash.Write(previousHash[:])
+	enc := bn254.NewEncoder(hash, bn254.RawEncoding())
+	for _, v := range []interface{}{&c.Delta1, &c.Delta2, &c.S, &c.SX, &c.RX} {
+		// Writing to a hash does not fail.
+		_ = enc.Encode(v)
+	}
+	var result [sha256.Size]byte
+	copy(result[:], hash.Sum(nil))
+	return result
+}
+
+// randomScalar derives a non-zero scalar from the seed and the entropy given
+// by the contributor.
+func randomScalar(label string, seed []byte, entropy []byte) fr.Element {
+	var scalar fr.Element
+	for counter := byte(0); scalar.IsZero(); counter++ {
+		hash := sha512.New()
+		hash.Write([]byte(label))
+		hash.Write([]byte{counter})
+		hash.Write(seed)
+		hash.Write(entropy)
+		scalar.SetBytes(hash.Sum(nil))
+	}
+	return scalar
+}
+
+// Contribute multiplies δ by a secret drawn from the randomness of the system
+// mixed with the entropy, and records the contribution.
+func (c *Ceremony) Contribute(entropy []byte) (*Contribution, error) {
+	seed := make([]byte, 64)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	x := randomScalar("x", seed, entropy)
+	s := randomScalar("s", seed, entropy)
+	var xInverse fr.Element
+	xInverse.Inverse(&x)
+	var xScalar, xInverseScalar, sScalar big.Int
+	x.BigInt(&xScalar)
+	xInverse.BigInt(&xInverseScalar)
+	s.BigInt(&sScalar)
+
+	previousHash := c.lastHash()
+	var contribution Contribution
+	_, _, g1, _ := bn254.Generators()
+	contribution.S.ScalarMultiplication(&g1, &sScalar)
+	contribution.SX.ScalarMultiplication(&contribution.S, &xScalar)
+	r, err := contribution.challenge(previousHash)
+	if err != nil {
+		return nil, err
+	}
+	contribution.RX.ScalarMultiplication(&r, &xScalar)
+
+	c.keys.delta1.ScalarMultiplication(&c.keys.delta1, &xScalar)
+	c.keys.delta2.ScalarMultiplication(&c.keys.delta2, &xScalar)
+	contribution.Delta1 = c.keys.delta1
+	contribution.Delta2 = c.keys.delta2
+	for _, points := range [][]bn254.G1Affine{c.keys.k, c.keys.z} {
+		parallelize(len(points), func(start int, end int) {
+			for i := start; i < end; i++ {
+				points[i].ScalarMultiplication(&points[i], &xInverseScalar)
+			}
+		})
+	}
+	x.SetZero()
+	xInverse.SetZero()
+	xScalar.SetInt64(0)
+	xInverseScalar.SetInt64(0)
+
+	contribution.Hash = contribution.hash(previousHash)
+	c.Contributions = append(c.Contributions, contribution)
+	return &contribution, nil
+}
+
+func (c *Ceremony) lastHash() [sha256.Size]byte {
+	if len(c.Contributions) == 0 {
+		return c.InitialHash
+	}
+	return c.Contributions[len(c.Contributions)-1].Hash
+}
+
+// sameRatio checks that a1 and b1 have the same ratio as a2 and b2, that is
+// e(a1, b2) = e(b1, a2).
+func sameRatio(a1 *bn254.G1Affine, b1 *bn254.G1Affine, a2 *bn254.G2Affine, b2 *bn254.G2Affine) bool {
+	var negB1 bn254.G1Affine
+	negB1.Neg(b1)
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{*a1, negB1}, []bn254.G2Affine{*b2, *a2})
+	return err == nil && ok
+}
+
+// verifyContributions checks the proof and the hash of every contribution,
+// and that the δ of the keys is the one of the last contribution.
+func (c *Ceremony) verifyContributions() error {
+	_, _, g1, g2 := bn254.Generators()
+	delta1, delta2 := g1, g2
+	previousHash := c.InitialHash
+	for i := range c.Contributions {
+		contribution := &c.Contributions[i]
+		r, err := contribution.challenge(previousHash)
+		if err != nil {
+			return err
+		}
+		switch {
+		case contribution.S.IsInfinity() || contribution.SX.IsInfinity() || contribution.Delta1.IsInfinity():
+			return fmt.Errorf("contribution %d has points at infinity", i+1)
+		case !contribution.RX.IsInSubGroup() || !contribution.Delta2.IsInSubGroup():
+			return fmt.Errorf("contribution %d has points outside of the G2 subgroup", i+1)
+		case !sameRatio(&contribution.S, &contribution.SX, &r, &contribution.RX):
+			return fmt.Errorf("contribution %d does not prove the knowledge of its secret", i+1)
+		case !sameRatio(&delta1, &contribution.Delta1, &r, &contribution.RX):
+			return fmt.Errorf("contribution %d did not multiply δ by its secret", i+1)
+		case !sameRatio(&g1, &contribution.Delta1, &g2, &contribution.Delta2):
+			return fmt.Errorf("contribution %d has different δ in G1 and G2", i+1)
+		case contribution.hash(previousHash) != contribution.Hash:
+			return fmt.Errorf("contribution %d has an invalid hash", i+1)
+		}
+		delta1, delta2 = contribution.Delta1, contribution.Delta2
+		previousHash = contribution.Hash
+	}
+	if !c.keys.delta1.Equal(&delta1) || !c.keys.delta2.Equal(&delta2) {
+		return fmt.Errorf("δ of the keys is not the one of the last contribution")
+	}
+	return nil
+}
+
+// Verify checks the whole ceremony against the powers of tau it started
+// from: the initial keys are computed again, every contribution is checked,
+// and the points divided by δ must be the initial ones divided by the product
+// of the secrets of the contributions.
+func (c *Ceremony) Verify(ptau io.ReadSeeker) error {
+	r1cs, err := buildR1CS(c.Mode, c.TreeDepth, c.BatchSize)
+	if err != nil {
+		return err
+	}
+	return c.verify(r1cs, ptau)
+}
+
+func (c *Ceremony) verify(r1cs *cs_bn254.R1CS, ptau io.ReadSeeker) error {
+	initial, err := initialKeys(r1cs, ptau)
+	if err != nil {
+		return err
+	}
+	if initial.hash() != c.InitialHash {
+		return fmt.Errorf("ceremony did not start from the keys of the circuit for the powers of tau")
+	}
+	if err := c.verifyContributions(); err != nil {
+		return err
+	}
+	if !c.keys.alpha1.Equal(&initial.alpha1) || !c.keys.beta1.Equal(&initial.beta1) ||
+		!c.keys.beta2.Equal(&initial.beta2) || !c.keys.gamma2.Equal(&initial.gamma2) ||
+		!equalG1(c.keys.a, initial.a) || !equalG1(c.keys.b1, initial.b1) || !equalG2(c.keys.b2, initial.b2) ||
+		!equalG1(c.keys.ic, initial.ic) {
+		return fmt.Errorf("keys have changed outside of δ")
+	}
+	if len(c.keys.k) != len(initial.k) || len(c.keys.z) != len(initial.z) {
+		return fmt.Errorf("keys have a wrong number of points")
+	}
+
+	// Every point must have been divided by δ, which is checked on a random
+	// linear combination of the points.
+	points := append(append([]bn254.G1Affine{}, c.keys.k...), c.keys.z...)
+	initialPoints := append(append([]bn254.G1Affine{}, initial.k...), initial.z...)
+	weights := make([]fr.Element, len(points))
+	for i := range weights {
+		if _, err := weights[i].SetRandom(); err != nil {
+			return err
+		}
+	}
+	var sum, initialSum bn254.G1Affine
+	config := ecc.MultiExpConfig{}
+	if _, err := sum.MultiExp(points, weights, config); err != nil {
+		return err
+	}
+	if _, err := initialSum.MultiExp(initialPoints, weights, config); err != nil {
+		return err
+	}
+	_, _, _, g2 := bn254.Generators()
+	if !sameRatio(&sum, &initialSum, &g2, &c.keys.delta2) {
+		return fmt.Errorf("points of the keys are not divided by δ")
+	}
+	return nil
+}
+
+func equalG1(a []bn254.G1Affine, b []bn254.G1Affine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalG2(a []bn254.G2Affine, b []bn254.G2Affine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finalize builds the proving system of the circuit from the keys of the
+// ceremony, after verifying the whole ceremony against the powers of tau it
+// started from, like Verify. A ceremony without contributions has a known δ,
+// and cannot be finalized.
+func (c *Ceremony) Finalize(ptau io.ReadSeeker) (*ProvingSystem, error) {
+	r1cs, err := buildR1CS(c.Mode, c.TreeDepth, c.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	pk, vk, err := c.finalKeys(r1cs, ptau)
+	if err != nil {
+		return nil, err
+	}
+	return &ProvingSystem{c.TreeDepth, c.BatchSize, pk, vk, r1cs}, nil
+}
+
+func (c *Ceremony) finalKeys(r1cs *cs_bn254.R1CS, ptau io.ReadSeeker) (groth16.ProvingKey, groth16.VerifyingKey, error) {
+	if len(c.Contributions) == 0 {
+		return nil, nil, fmt.Errorf("ceremony has no contributions")
+	}
+	if err := c.verify(r1cs, ptau); err != nil {
+		return nil, nil, err
+	}
+	return c.keys.gnarkKeys()
+}
+
+// WriteTo writes the ceremony in the layout of a ceremony file.
+func (c *Ceremony) WriteTo(w io.Writer) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	var header []byte
+	header = binary.BigEndian.AppendUint32(header, c.TreeDepth)
+	header = binary.BigEndian.AppendUint32(header, c.BatchSize)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(c.Mode)))
+	header = append(header, c.Mode...)
+	header = append(header, c.InitialHash[:]...)
+	if _, err := buffered.Write(header); err != nil {
+		return 0, err
+	}
+
+	enc := bn254.NewEncoder(buffered, bn254.RawEncoding())
+	written := func() int64 {
+		return int64(len(header)) + enc.BytesWritten() + int64(len(c.Contributions))*sha256.Size
+	}
+	if err := c.keys.encode(enc); err != nil {
+		return written(), err
+	}
+	if err := enc.Encode(uint64(len(c.Contributions))); err != nil {
+		return written(), err
+	}
+	for i := range c.Contributions {
+		contribution := &c.Contributions[i]
+		for _, v := range []interface{}{&contribution.Delta1, &contribution.Delta2, &contribution.S, &contribution.SX, &contribution.RX} {
+			if err := enc.Encode(v); err != nil {
+				return written(), err
+			}
+		}
+		if _, err := buffered.Write(contribution.Hash[:]); err != nil {
+			return written(), err
+		}
+	}
+	return written(), buffered.Flush()
+}
+
+// ReadCeremonyFromFile reads a ceremony from a file written by WriteTo.
+func ReadCeremonyFromFile(path string) (*Ceremony, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCeremony(file)
+}
+
+// ReadCeremony reads a ceremony written by WriteTo. Its points are checked to
+// be on the curve and in the right subgroup.
+func ReadCeremony(r io.Reader) (*Ceremony, error) {
+	reader := bufio.NewReader(r)
+	var header struct {
+		TreeDepth  uint32
+		BatchSize  uint32
+		ModeLength uint32
+	}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if header.ModeLength > uint32(len(insertionMode)+len(deletionMode)) {
+		return nil, fmt.Errorf("not a ceremony file")
+	}
+	mode := make([]byte, header.ModeLength)
+	if _, err := io.ReadFull(reader, mode); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	c := &Ceremony{Mode: string(mode), TreeDepth: header.TreeDepth, BatchSize: header.BatchSize}
+	if _, err := io.ReadFull(reader, c.InitialHash[:]); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	dec := bn254.NewDecoder(reader)
+	if err := c.keys.decode(dec); err != nil {
+		return nil, fmt.Errorf("reading keys: %w", err)
+	}
+	var nbContributions uint64
+	if err := dec.Decode(&nbContributions); err != nil {
+		return nil, fmt.Errorf("reading contributions: %w", err)
+	}
+	for i := uint64(0); i < nbContributions; i++ {
+		var contribution Contribution
+		for _, v := range []interface{}{&contribution.Delta1, &contribution.Delta2, &contribution.S, &contribution.SX, &contribution.RX} {
+			if err := dec.Decode(v); err != nil {
+				return nil, fmt.Errorf("reading contribution %d: %w", i+1, err)
+			}
+		}
+		if _, err := io.ReadFull(reader, contribution.Hash[:]); err != nil {
+			return nil, fmt.Errorf("reading contribution %d: %w", i+1, err)
+		}
+		c.Contributions = append(c.Contributions, contribution)
+	}
+	return c, nil
+}
@@ -0,0 +1,141 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+// writeTestPtau writes the powers of tau of a phase-1 ceremony of the given
+// power, prepared for phase 2, with toxic waste of known value.
+func writeTestPtau(power int) []byte {
+	var tau, alpha, beta fr.Element
+	tau.SetUint64(7654321)
+	alpha.SetUint64(23)
+	beta.SetUint64(29)
+
+	var data bytes.Buffer
+	section := func(sectionType uint32, write func()) { writeTestSection(&data, sectionType, write) }
+	powers := func(n int, factor fr.Element, write func(*bytes.Buffer, fr.Element)) {
+		value := factor
+		for i := 0; i < n; i++ {
+			write(&data, value)
+			value.Mul(&value, &tau)
+		}
+	}
+	lagrangePowers := func(factor fr.Element, write func(*bytes.Buffer, fr.Element)) {
+		for k := 0; k <= power; k++ {
+			n := 1 << k
+			for _, value := range lagrange(&tau, &fft.NewDomain(uint64(n)).Generator, n) {
+				write(&data, *value.Mul(&value, &factor))
+			}
+		}
+	}
+	var one fr.Element
+	one.SetOne()
+
+	data.Write(ptauMagic[:])
+	data.Write(binary.LittleEndian.AppendUint32(nil, ptauVersion))
+	data.Write(binary.LittleEndian.AppendUint32(nil, 10))
+	section(ptauHeaderSection, func() {
+		data.Write(binary.LittleEndian.AppendUint32(nil, fpSize))
+		data.Write(toBytesLE(fp.Modulus().FillBytes(make([]byte, fpSize))))
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(power)))
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(power)))
+	})
+	section(ptauTauG1Section, func() { powers(2<<power-1, one, writeTestG1) })
+	section(3, func() { powers(1<<power, one, writeTestG2) })
+	section(ptauAlphaTauG1Section, func() { powers(1<<power, alpha, writeTestG1) })
+	section(ptauBetaTauG1Section, func() { powers(1<<power, beta, writeTestG1) })
+	section(ptauBetaG2Section, func() { writeTestG2(&data, beta) })
+	section(ptauLagrangeG1Section, func() { lagrangePowers(one, writeTestG1) })
+	section(ptauLagrangeG2Section, func() { lagrangePowers(one, writeTestG2) })
+	section(ptauAlphaLagrangeG1Section, func() { lagrangePowers(alpha, writeTestG1) })
+	section(ptauBetaLagrangeG1Section, func() { lagrangePowers(beta, writeTestG1) })
+	return data.Bytes()
+}
+
+func newTestCeremony(t *testing.T, ptau []byte) *Ceremony {
+	keys, err := initialKeys(compileIden3Circuit(t, 3), bytes.NewReader(ptau))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Ceremony{InitialHash: keys.hash(), keys: *keys}
+}
+
+// The keys of a ceremony with contributions must prove the circuit, and the
+// ceremony must verify against the powers of tau it started from.
+func TestCeremony(t *testing.T) {
+	ptau := writeTestPtau(4)
+	ccs := compileIden3Circuit(t, 3)
+	ceremony := newTestCeremony(t, ptau)
+	if _, _, err := ceremony.finalKeys(ccs, bytes.NewReader(ptau)); err == nil {
+		t.Fatal("finalized a ceremony without contributions")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ceremony.Contribute([]byte("entropy")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var file bytes.Buffer
+	if _, err := ceremony.WriteTo(&file); err != nil {
+		t.Fatal(err)
+	}
+	ceremony, err := ReadCeremony(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ceremony.Contributions[1].Hash != ceremony.Contributions[1].hash(ceremony.Contributions[0].Hash) {
+		t.Fatal("contribution hashes are not chained")
+	}
+	if err := ceremony.verify(ccs, bytes.NewReader(ptau)); err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := ceremony.finalKeys(ccs, bytes.NewReader(ptau))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proveTestIden3(t, ccs, pk, vk)
+}
+
+func TestCeremonyTampering(t *testing.T) {
+	ptau := writeTestPtau(4)
+	ccs := compileIden3Circuit(t, 3)
+	ceremony := newTestCeremony(t, ptau)
+	if _, err := ceremony.Contribute(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A point left undivided by the secret of the contribution.
+	ceremony.keys.z[1] = newTestCeremony(t, ptau).keys.z[1]
+	err := ceremony.verify(ccs, bytes.NewReader(ptau))
+	if err == nil || !strings.Contains(err.Error(), "divided by δ") {
+		t.Fatalf("unexpected error for an undivided point: %v", err)
+	}
+	if _, _, err := ceremony.finalKeys(ccs, bytes.NewReader(ptau)); err == nil {
+		t.Fatal("finalized a ceremony with an undivided point")
+	}
+
+	// δ multiplied without a contribution proving it.
+	ceremony = newTestCeremony(t, ptau)
+	if _, err := ceremony.Contribute(nil); err != nil {
+		t.Fatal(err)
+	}
+	ceremony.Contributions[0].Delta1 = ceremony.Contributions[0].SX
+	if _, _, err := ceremony.finalKeys(ccs, bytes.NewReader(ptau)); err == nil {
+		t.Fatal("finalized a ceremony with an invalid contribution")
+	}
+
+	if err := ceremony.verify(compileIden3Circuit(t, 4), bytes.NewReader(ptau)); err == nil {
+		t.Fatal("verified a ceremony against another circuit")
+	}
+	if _, err := initialKeys(ccs, bytes.NewReader(writeTestPtau(1))); err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Fatalf("unexpected error for too few powers of tau: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package prover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+)
+
+// CheckKeys checks what reading a keys file leaves unchecked: that every point
+// of the keys is in its subgroup, that the keys have the dimensions of the
+// constraint system, and that the proving and verifying keys come from the
+// same setup. It does not prove anything, see SelfTestInsertion and
+// SelfTestDeletion for that.
+func (ps *ProvingSystem) CheckKeys() error {
+	var domain fft.Domain
+	var alpha1, beta1, delta1 bn254.G1Affine
+	var beta2, delta2 bn254.G2Affine
+	var a, b1, z, k []bn254.G1Affine
+	var b2 []bn254.G2Affine
+	var nbWires, nbInfinityA, nbInfinityB uint64
+	var infinityA, infinityB []bool
+	err := checkedDecode(ps.ProvingKey, func(r io.Reader) error {
+		if _, err := domain.ReadFrom(r); err != nil {
+			return err
+		}
+		dec := bn254.NewDecoder(r)
+		for _, v := range []interface{}{
+			&alpha1, &beta1, &delta1, &a, &b1, &z, &k, &beta2, &delta2, &b2,
+			&nbWires, &nbInfinityA, &nbInfinityB,
+		} {
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+		}
+		if nbWires > 1<<32 {
+			return fmt.Errorf("proving key has %d wires", nbWires)
+		}
+		infinityA = make([]bool, nbWires)
+		infinityB = make([]bool, nbWires)
+		for _, v := range []interface{}{&infinityA, &infinityB} {
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid proving key: %w", err)
+	}
+
+	var vkAlpha1, vkBeta1, vkDelta1 bn254.G1Affine
+	var vkBeta2, vkGamma2, vkDelta2 bn254.G2Affine
+	var ic []bn254.G1Affine
+	err = checkedDecode(ps.VerifyingKey, func(r io.Reader) error {
+		dec := bn254.NewDecoder(r)
+		for _, v := range []interface{}{&vkAlpha1, &vkBeta1, &vkBeta2, &vkGamma2, &vkDelta1, &vkDelta2, &ic} {
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid verifying key: %w", err)
+	}
+
+	ccs := ps.ConstraintSystem
+	nbPublic := ccs.GetNbPublicVariables()
+	ccsWires := nbPublic + ccs.GetNbSecretVariables() + ccs.GetNbInternalVariables()
+	if nbWires != uint64(ccsWires) {
+		return fmt.Errorf("proving key has %d wires, the constraint system has %d", nbWires, ccsWires)
+	}
+	if countFlags(infinityA) != nbInfinityA || uint64(len(a)) != nbWires-nbInfinityA {
+		return fmt.Errorf("proving key has %d points in A for %d wires, %d of them flagged at infinity", len(a), nbWires, countFlags(infinityA))
+	}
+	if countFlags(infinityB) != nbInfinityB || uint64(len(b1)) != nbWires-nbInfinityB || len(b2) != len(b1) {
+		return fmt.Errorf("proving key has %d and %d points in B for %d wires, %d of them flagged at infinity", len(b1), len(b2), nbWires, countFlags(infinityB))
+	}
+	if len(k) != ccsWires-nbPublic {
+		return fmt.Errorf("proving key has %d points in K, the constraint system has %d private wires", len(k), ccsWires-nbPublic)
+	}
+	if len(ic) != nbPublic {
+		return fmt.Errorf("verifying key has %d points in IC, the constraint system has %d public wires", len(ic), nbPublic)
+	}
+	size := fft.NewDomain(uint64(ccs.GetNbConstraints())).Cardinality
+	if domain.Cardinality != size || uint64(len(z)) != size {
+		return fmt.Errorf("proving key has a domain of size %d and %d points in Z, the constraint system needs %d", domain.Cardinality, len(z), size)
+	}
+
+	if !alpha1.Equal(&vkAlpha1) || !beta1.Equal(&vkBeta1) || !delta1.Equal(&vkDelta1) || !beta2.Equal(&vkBeta2) || !delta2.Equal(&vkDelta2) {
+		return fmt.Errorf("proving and verifying keys do not come from the same setup")
+	}
+	if alpha1.IsInfinity() || beta1.IsInfinity() || delta1.IsInfinity() || beta2.IsInfinity() || delta2.IsInfinity() || vkGamma2.IsInfinity() {
+		return fmt.Errorf("verifying key has a point at infinity for α, β, γ or δ")
+	}
+	return nil
+}
+
+func countFlags(flags []bool) uint64 {
+	n := uint64(0)
+	for _, flag := range flags {
+		if flag {
+			n++
+		}
+	}
+	return n
+}
+
+// checkedDecode reads the compressed encoding of a key of gnark with decode.
+// The decoder of gnark-crypto only checks the subgroup of the points of a
+// slice when it decompresses them, the raw encoding of the keys files is not
+// checked.
+func checkedDecode(key io.WriterTo, decode func(r io.Reader) error) error {
+	reader, writer := io.Pipe()
+	go func() {
+		buffered := bufio.NewWriter(writer)
+		_, err := key.WriteTo(buffered)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		writer.CloseWithError(err)
+	}()
+	err := decode(bufio.NewReader(reader))
+	reader.CloseWithError(err)
+	return err
+}
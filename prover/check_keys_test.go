@@ -0,0 +1,78 @@
+package prover
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// outsideG2 returns a point of the curve over Fp2 outside of the G2 subgroup.
+func outsideG2(t *testing.T) bn254.G2Affine {
+	// y² = x³ + 3/(9 + u)
+	var point, b bn254.G2Affine
+	b.X.A0.SetUint64(9)
+	b.X.A1.SetOne()
+	b.X.Inverse(&b.X)
+	b.Y.A0.SetUint64(3)
+	b.X.Mul(&b.X, &b.Y)
+	for x := uint64(1); ; x++ {
+		point.X.A0.SetUint64(x)
+		point.Y.Square(&point.X).Mul(&point.Y, &point.X).Add(&point.Y, &b.X)
+		if point.Y.Legendre() == 1 {
+			point.Y.Sqrt(&point.Y)
+			break
+		}
+	}
+	if !point.IsOnCurve() || point.IsInSubGroup() {
+		t.Fatal("point is not on the curve outside of G2")
+	}
+	return point
+}
+
+func TestCheckKeys(t *testing.T) {
+	ccs := compileIden3Circuit(t, 3)
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := ProvingSystem{ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: ccs}
+	if err := ps.CheckKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherVk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := ProvingSystem{ProvingKey: pk, VerifyingKey: otherVk, ConstraintSystem: ccs}
+	if err := mismatched.CheckKeys(); err == nil || !strings.Contains(err.Error(), "same setup") {
+		t.Fatalf("unexpected error for keys of two setups: %v", err)
+	}
+
+	otherCcs := ProvingSystem{ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: compileIden3Circuit(t, 4)}
+	if err := otherCcs.CheckKeys(); err == nil || !strings.Contains(err.Error(), "wires") {
+		t.Fatalf("unexpected error for another constraint system: %v", err)
+	}
+
+	keys, err := initialKeys(ccs, bytes.NewReader(writeTestPtau(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range keys.b2 {
+		if !keys.b2[i].IsInfinity() {
+			keys.b2[i] = outsideG2(t)
+			break
+		}
+	}
+	pk, vk, err = keys.gnarkKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := ProvingSystem{ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: ccs}
+	if err := outside.CheckKeys(); err == nil || !strings.Contains(err.Error(), "invalid proving key") {
+		t.Fatalf("unexpected error for a point outside of G2: %v", err)
+	}
+}
@@ -1,15 +1,21 @@
 package prover
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"math/big"
 	"os"
 	"strconv"
+	"time"
 	"worldcoin/gnark-mbu/logging"
 	"worldcoin/gnark-mbu/prover/poseidon"
 
 	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
 	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
 	"github.com/reilabs/gnark-lean-extractor/v2/abstractor"
@@ -17,6 +23,8 @@ import (
 
 type Proof struct {
 	Proof groth16.Proof
+	// InputHash is the public input the proof was generated for, when known.
+	InputHash *big.Int
 }
 
 type ProvingSystem struct {
@@ -297,6 +305,89 @@ func toBytesLE(b []byte) []byte {
 	return b
 }
 
+// prove builds the witness for the assignment and generates a proof for it,
+// recording the time each step takes, and giving up once ctx is done. The
+// context is checked before building the witness, before proving, and every
+// time the constraint solver calls a hint. The rest of the proving algorithm
+// cannot be interrupted, and a proof finished after ctx is done is discarded.
+func (ps *ProvingSystem) prove(ctx context.Context, mode string, assignment frontend.Circuit) (*Proof, error) {
+	if err := ctx.Err(); err != nil {
+		proofsAbandoned.WithLabelValues(mode).Inc()
+		return nil, err
+	}
+	w, err := ps.newWitness(mode, assignment)
+	if err != nil {
+		return nil, err
+	}
+	return ps.proveWitness(ctx, mode, w.witness)
+}
+
+func (ps *ProvingSystem) proveWitness(ctx context.Context, mode string, witness witness.Witness) (*Proof, error) {
+	if err := ctx.Err(); err != nil {
+		proofsAbandoned.WithLabelValues(mode).Inc()
+		return nil, err
+	}
+
+	logging.Logger().Info().Msg("generating proof")
+	proofsInFlight.Inc()
+	defer proofsInFlight.Dec()
+	start := time.Now()
+	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness, cancellableHints(ctx))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		proofsAbandoned.WithLabelValues(mode).Inc()
+		logging.Logger().Info().Err(ctxErr).Msg("proof abandoned")
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	observeDuration(provingDuration, mode, ps.BatchSize, start)
+	logging.Logger().Info().Msg("proof generated successfully")
+	return &Proof{Proof: proof}, nil
+}
+
+// cancellableHints wraps the hint functions available to the constraint solver
+// so that they fail once ctx is done, which stops the solver early.
+func cancellableHints(ctx context.Context) backend.ProverOption {
+	return func(config *backend.ProverConfig) error {
+		for id, function := range config.HintFunctions {
+			function := function
+			config.HintFunctions[id] = func(field *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return function(field, inputs, outputs)
+			}
+		}
+		return nil
+	}
+}
+
+func (ps *ProvingSystem) verify(mode string, publicAssignment frontend.Circuit, proof *Proof) error {
+	start := time.Now()
+	witness, err := frontend.NewWitness(publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	err = groth16.Verify(proof.Proof, ps.VerifyingKey, witness)
+	if err != nil {
+		return err
+	}
+	observeDuration(verifyingDuration, mode, ps.BatchSize, start)
+	return nil
+}
+
+// VerifyingKeyHash returns the SHA-256 hash of the verifying key serialized the
+// same way as by the export-vk command.
+func (ps *ProvingSystem) VerifyingKeyHash() ([]byte, error) {
+	hash := sha256.New()
+	_, err := ps.VerifyingKey.WriteTo(hash)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
 func (ps *ProvingSystem) ExportSolidity(writer io.Writer) error {
 	return ps.VerifyingKey.ExportSolidity(writer)
 }
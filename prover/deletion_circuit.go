@@ -32,22 +32,8 @@ func (circuit *DeletionMbuCircuit) Define(api frontend.API) error {
 		return fmt.Errorf("max depth supported is 31")
 	}
 	// Hash private inputs.
-	// We keccak hash all input to save verification gas. Inputs are arranged as follows:
-	// deletionIndices[0] || deletionIndices[1] || ... || deletionIndices[batchSize-1] || PreRoot || PostRoot
-	//        32          ||        32          || ... ||              32              ||   256   ||    256
-	var bits []frontend.Variable
-
-	for i := 0; i < circuit.BatchSize; i++ {
-		bits_idx := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.DeletionIndices[i], Size: 32})
-		bits = append(bits, bits_idx...)
-	}
-
-	bits_pre := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PreRoot, Size: 256})
-	bits = append(bits, bits_pre...)
-
-	bits_post := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PostRoot, Size: 256})
-	bits = append(bits, bits_post...)
-
+	// We keccak hash all input to save verification gas.
+	bits := circuit.inputHashPreimage(api)
 	hash := keccak.NewKeccak256(api, circuit.BatchSize*32+2*256, bits...)
 	sum := abstractor.Call(api, FromBinaryBigEndian{Variable: hash})
 
@@ -71,6 +57,27 @@ func (circuit *DeletionMbuCircuit) Define(api frontend.API) error {
 	return nil
 }
 
+// inputHashPreimage returns the bits hashed into InputHash, matching the bytes
+// of DeletionParameters.InputHashPreimage.
+func (circuit *DeletionMbuCircuit) inputHashPreimage(api frontend.API) []frontend.Variable {
+	// Inputs are arranged as follows:
+	// deletionIndices[0] || deletionIndices[1] || ... || deletionIndices[batchSize-1] || PreRoot || PostRoot
+	//        32          ||        32          || ... ||              32              ||   256   ||    256
+	var bits []frontend.Variable
+
+	for i := 0; i < circuit.BatchSize; i++ {
+		bits_idx := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.DeletionIndices[i], Size: 32})
+		bits = append(bits, bits_idx...)
+	}
+
+	bits_pre := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PreRoot, Size: 256})
+	bits = append(bits, bits_pre...)
+
+	bits_post := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PostRoot, Size: 256})
+	bits = append(bits, bits_post...)
+	return bits
+}
+
 func ImportDeletionSetup(treeDepth uint32, batchSize uint32, pkPath string, vkPath string) (*ProvingSystem, error) {
 	proofs := make([][]frontend.Variable, batchSize)
 	for i := 0; i < int(batchSize); i++ {
@@ -1,11 +1,9 @@
 package prover
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"math/big"
-	"worldcoin/gnark-mbu/logging"
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
@@ -47,35 +45,29 @@ func (p *DeletionParameters) ValidateShape(treeDepth uint32, batchSize uint32) e
 // It uses big-endian byte ordering (network ordering) in order to agree with
 // Solidity and avoid the need to perform the byte swapping operations on-chain
 // where they would increase our gas cost.
-func (p *DeletionParameters) ComputeInputHashDeletion() error {
-	var data []byte
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, p.DeletionIndices)
-	if err != nil {
-		return err
-	}
-	data = append(data, buf.Bytes()...)
-	data = append(data, p.PreRoot.Bytes()...)
-	data = append(data, p.PostRoot.Bytes()...)
-
-	hashBytes := keccak256.Hash(data)
+func (p *DeletionParameters) ComputeInputHashDeletion() {
+	hashBytes := keccak256.Hash(p.InputHashPreimage())
 	p.InputHash.SetBytes(hashBytes)
-	return nil
 }
 
 func BuildR1CSDeletion(treeDepth uint32, batchSize uint32) (constraint.ConstraintSystem, error) {
+	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, deletionPlaceholder(treeDepth, batchSize))
+}
+
+// deletionPlaceholder returns the circuit to compile for the tree depth and
+// batch size.
+func deletionPlaceholder(treeDepth uint32, batchSize uint32) *DeletionMbuCircuit {
 	proofs := make([][]frontend.Variable, batchSize)
 	for i := 0; i < int(batchSize); i++ {
 		proofs[i] = make([]frontend.Variable, treeDepth)
 	}
-	circuit := DeletionMbuCircuit{
+	return &DeletionMbuCircuit{
 		Depth:           int(treeDepth),
 		BatchSize:       int(batchSize),
 		DeletionIndices: make([]frontend.Variable, batchSize),
 		IdComms:         make([]frontend.Variable, batchSize),
 		MerkleProofs:    proofs,
 	}
-	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
 }
 
 func SetupDeletion(treeDepth uint32, batchSize uint32) (*ProvingSystem, error) {
@@ -91,10 +83,37 @@ func SetupDeletion(treeDepth uint32, batchSize uint32) (*ProvingSystem, error) {
 }
 
 func (ps *ProvingSystem) ProveDeletion(params *DeletionParameters) (*Proof, error) {
+	return ps.ProveDeletionContext(context.Background(), params)
+}
+
+// ProveDeletionContext is like ProveDeletion, but gives up on the proof once ctx is
+// done, returning the error of the context.
+func (ps *ProvingSystem) ProveDeletionContext(ctx context.Context, params *DeletionParameters) (*Proof, error) {
 	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
 		return nil, err
 	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	proof, err := ps.prove(ctx, deletionMode, ps.deletionAssignment(params))
+	if err != nil {
+		return nil, err
+	}
+	proof.InputHash = new(big.Int).Set(&params.InputHash)
+	return proof, nil
+}
 
+// DeletionWitness builds the witness of the deletion circuit for the
+// parameters. Apart from their shape, the parameters are not validated, so
+// that the witness of a failing batch can be captured.
+func (ps *ProvingSystem) DeletionWitness(params *DeletionParameters) (*Witness, error) {
+	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
+		return nil, err
+	}
+	return ps.newWitness(deletionMode, ps.deletionAssignment(params))
+}
+
+func (ps *ProvingSystem) deletionAssignment(params *DeletionParameters) *DeletionMbuCircuit {
 	deletionIndices := make([]frontend.Variable, ps.BatchSize)
 	for i := 0; i < int(ps.BatchSize); i++ {
 		deletionIndices[i] = params.DeletionIndices[i]
@@ -111,7 +130,7 @@ func (ps *ProvingSystem) ProveDeletion(params *DeletionParameters) (*Proof, erro
 			proofs[i][j] = params.MerkleProofs[i][j]
 		}
 	}
-	assignment := DeletionMbuCircuit{
+	return &DeletionMbuCircuit{
 		InputHash:       params.InputHash,
 		DeletionIndices: deletionIndices,
 		PreRoot:         params.PreRoot,
@@ -119,17 +138,6 @@ func (ps *ProvingSystem) ProveDeletion(params *DeletionParameters) (*Proof, erro
 		IdComms:         idComms,
 		MerkleProofs:    proofs,
 	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		return nil, err
-	}
-	logging.Logger().Info().Msg("generating proof")
-	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
-	if err != nil {
-		return nil, err
-	}
-	logging.Logger().Info().Msg("proof generated successfully")
-	return &Proof{proof}, nil
 }
 
 func (ps *ProvingSystem) VerifyDeletion(inputHash big.Int, proof *Proof) error {
@@ -137,9 +145,5 @@ func (ps *ProvingSystem) VerifyDeletion(inputHash big.Int, proof *Proof) error {
 		InputHash:       inputHash,
 		DeletionIndices: make([]frontend.Variable, ps.BatchSize),
 	}
-	witness, err := frontend.NewWitness(&publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
-	if err != nil {
-		return err
-	}
-	return groth16.Verify(proof.Proof, ps.VerifyingKey, witness)
+	return ps.verify(deletionMode, &publicAssignment, proof)
 }
@@ -0,0 +1,388 @@
+package prover
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+)
+
+// The binary formats of iden3's circom and snarkjs share a layout: a magic
+// string, a version and the number of sections, then every section as its
+// type, its size in bytes and its content. Numbers are little-endian, and
+// field elements are written in 32 little-endian bytes, in regular form.
+//
+// The r1cs format holds the constraints of the circuit:
+//
+//	header:    "r1cs", version 1 and the number of sections, 3
+//	section 1: field size 32, the modulus, the number of wires, outputs,
+//	           public inputs and private inputs, the number of labels as
+//	           uint64 and the number of constraints
+//	section 2: the constraints, as their A, B and C linear combinations
+//	           such that A * B = C, each as the number of terms followed by
+//	           the wire and the coefficient of every term
+//	section 3: the label of every wire as uint64
+//
+// Wires keep their gnark numbering, which puts them in the same order as the
+// wires of a wtns file: the constant one, the public input, the secret inputs
+// and then the internal wires. The circuits have no outputs, and wires are
+// their own labels. The names of the labels are written separately, in the
+// sym format of circom.
+var r1csMagic = [4]byte{'r', '1', 'c', 's'}
+
+const (
+	r1csVersion           = 1
+	r1csHeaderSection     = 1
+	r1csConstraintSection = 2
+	r1csLabelSection      = 3
+)
+
+type iden3Writer struct {
+	writer *bufio.Writer
+	buf    [fpSize]byte
+	err    error
+}
+
+func newIden3Writer(writer io.Writer, magic [4]byte, version uint32, nbSections uint32) *iden3Writer {
+	w := &iden3Writer{writer: bufio.NewWriter(writer)}
+	_, w.err = w.writer.Write(magic[:])
+	w.uint32(version)
+	w.uint32(nbSections)
+	return w
+}
+
+func (w *iden3Writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.writer.Write(data)
+}
+
+func (w *iden3Writer) uint32(value uint32) {
+	w.write(binary.LittleEndian.AppendUint32(w.buf[:0], value))
+}
+
+func (w *iden3Writer) uint64(value uint64) {
+	w.write(binary.LittleEndian.AppendUint64(w.buf[:0], value))
+}
+
+func (w *iden3Writer) section(sectionType uint32, size uint64) {
+	w.uint32(sectionType)
+	w.uint64(size)
+}
+
+func (w *iden3Writer) element(value *fr.Element) {
+	bytes := value.Bytes()
+	w.write(toBytesLE(bytes[:]))
+}
+
+// fieldHeader writes the field size and the modulus of the scalar field.
+func (w *iden3Writer) fieldHeader() {
+	w.uint32(fpSize)
+	w.write(toBytesLE(ecc.BN254.ScalarField().FillBytes(make([]byte, fpSize))))
+}
+
+func (w *iden3Writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.writer.Flush()
+}
+
+type iden3Section struct {
+	offset int64
+	size   int64
+}
+
+// readIden3Sections reads the header of a file in an iden3 binary format,
+// returning its version and where each of its sections is.
+func readIden3Sections(r io.ReadSeeker, magic [4]byte) (uint32, map[uint32]iden3Section, error) {
+	var header struct {
+		Magic      [4]byte
+		Version    uint32
+		NbSections uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, nil, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Magic != magic {
+		return 0, nil, fmt.Errorf("not a %s file", magic[:])
+	}
+	sections := make(map[uint32]iden3Section)
+	for i := uint32(0); i < header.NbSections; i++ {
+		var section struct {
+			Type uint32
+			Size uint64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &section); err != nil {
+			return 0, nil, fmt.Errorf("reading section header: %w", err)
+		}
+		if _, ok := sections[section.Type]; ok {
+			return 0, nil, fmt.Errorf("duplicate section %d", section.Type)
+		}
+		offset, err := r.Seek(int64(section.Size), io.SeekCurrent)
+		if err != nil {
+			return 0, nil, err
+		}
+		sections[section.Type] = iden3Section{offset: offset - int64(section.Size), size: int64(section.Size)}
+	}
+	return header.Version, sections, nil
+}
+
+// iden3Reader reads the sections of a file in an iden3 binary format, keeping
+// the first error. Points are in affine coordinates, each coordinate in 32
+// little-endian bytes in Montgomery form.
+type iden3Reader struct {
+	name     string
+	r        io.ReadSeeker
+	sections map[uint32]iden3Section
+	reader   *bufio.Reader
+	buf      [fpSize]byte
+	err      error
+}
+
+// open starts reading a section, which must be of the given size.
+func (r *iden3Reader) open(sectionType uint32, size int64) {
+	if section, ok := r.sections[sectionType]; ok && r.err == nil && section.size != size {
+		r.err = fmt.Errorf("%s section %d has %d bytes, expected %d", r.name, sectionType, section.size, size)
+	}
+	r.openAt(sectionType, 0, size)
+}
+
+// openAt starts reading size bytes of a section, from offset.
+func (r *iden3Reader) openAt(sectionType uint32, offset int64, size int64) {
+	if r.err != nil {
+		return
+	}
+	section, ok := r.sections[sectionType]
+	if !ok {
+		r.err = fmt.Errorf("%s has no section %d", r.name, sectionType)
+		return
+	}
+	if offset+size > section.size {
+		r.err = fmt.Errorf("%s section %d has %d bytes, expected at least %d", r.name, sectionType, section.size, offset+size)
+		return
+	}
+	if _, err := r.r.Seek(section.offset+offset, io.SeekStart); err != nil {
+		r.err = err
+		return
+	}
+	r.reader = bufio.NewReaderSize(io.LimitReader(r.r, size), 1<<20)
+}
+
+func (r *iden3Reader) read(size int) []byte {
+	if r.err != nil {
+		return r.buf[:size]
+	}
+	_, r.err = io.ReadFull(r.reader, r.buf[:size])
+	return r.buf[:size]
+}
+
+func (r *iden3Reader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *iden3Reader) bigInt() *big.Int {
+	return new(big.Int).SetBytes(toBytesLE(r.read(fpSize)))
+}
+
+// fp reads a coordinate in Montgomery form, which is how gnark holds it.
+func (r *iden3Reader) fp(element *fp.Element) {
+	data := r.read(fpSize)
+	for i := range element {
+		element[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	if r.err == nil && new(big.Int).SetBytes(toBytesLE(data)).Cmp(fp.Modulus()) >= 0 {
+		r.err = fmt.Errorf("%s holds a coordinate outside of the base field", r.name)
+	}
+}
+
+func (r *iden3Reader) g1(point *bn254.G1Affine) {
+	r.fp(&point.X)
+	r.fp(&point.Y)
+	if r.err == nil && !point.IsOnCurve() {
+		r.err = fmt.Errorf("%s holds a point outside of G1", r.name)
+	}
+}
+
+func (r *iden3Reader) g2(point *bn254.G2Affine) {
+	r.fp(&point.X.A0)
+	r.fp(&point.X.A1)
+	r.fp(&point.Y.A0)
+	r.fp(&point.Y.A1)
+	if r.err == nil && !point.IsOnCurve() {
+		r.err = fmt.Errorf("%s holds a point outside of G2", r.name)
+	}
+}
+
+func (r *iden3Reader) g1s(n int) []bn254.G1Affine {
+	points := make([]bn254.G1Affine, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		r.g1(&points[i])
+	}
+	return points
+}
+
+func (r *iden3Reader) g2s(n int) []bn254.G2Affine {
+	points := make([]bn254.G2Affine, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		r.g2(&points[i])
+	}
+	return points
+}
+
+type iden3Term struct {
+	wire  uint32
+	coeff fr.Element
+}
+
+// iden3Terms returns the terms of the linear expression sorted by wire, with
+// the terms of the same wire added up and the null ones left out.
+func iden3Terms(r1cs *cs_bn254.R1CS, expression constraint.LinearExpression, terms []iden3Term) []iden3Term {
+	terms = terms[:0]
+	for _, term := range expression {
+		if term.CoeffID() == constraint.CoeffIdZero {
+			continue
+		}
+		wire := term.VID
+		if term.IsConstant() {
+			wire = 0
+		}
+		terms = append(terms, iden3Term{wire, r1cs.Coefficients[term.CID]})
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].wire < terms[j].wire })
+	merged := terms[:0]
+	for _, term := range terms {
+		if n := len(merged); n > 0 && merged[n-1].wire == term.wire {
+			merged[n-1].coeff.Add(&merged[n-1].coeff, &term.coeff)
+			continue
+		}
+		merged = append(merged, term)
+	}
+	result := merged[:0]
+	for _, term := range merged {
+		if !term.coeff.IsZero() {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// WriteR1CSIden3 writes the constraint system in the r1cs format of iden3,
+// which snarkjs can read.
+func WriteR1CSIden3(writer io.Writer, cs constraint.ConstraintSystem) error {
+	r1cs, ok := cs.(*cs_bn254.R1CS)
+	if !ok {
+		return fmt.Errorf("unsupported constraint system: %T", cs)
+	}
+	nbPublic := r1cs.GetNbPublicVariables()
+	nbSecret := r1cs.GetNbSecretVariables()
+	nbWires := nbPublic + nbSecret + r1cs.GetNbInternalVariables()
+
+	// The constraints are walked twice, to know the size of their section
+	// before writing them.
+	var terms []iden3Term
+	constraintsSize := uint64(0)
+	for _, r1c := range r1cs.Constraints {
+		for _, expression := range []constraint.LinearExpression{r1c.L, r1c.R, r1c.O} {
+			terms = iden3Terms(r1cs, expression, terms)
+			constraintsSize += 4 + uint64(len(terms))*(4+fpSize)
+		}
+	}
+
+	w := newIden3Writer(writer, r1csMagic, r1csVersion, 3)
+	w.section(r1csHeaderSection, 4+fpSize+4*4+8+4)
+	w.fieldHeader()
+	w.uint32(uint32(nbWires))
+	w.uint32(0)
+	w.uint32(uint32(nbPublic - 1))
+	w.uint32(uint32(nbSecret))
+	w.uint64(uint64(nbWires))
+	w.uint32(uint32(len(r1cs.Constraints)))
+
+	w.section(r1csConstraintSection, constraintsSize)
+	for _, r1c := range r1cs.Constraints {
+		for _, expression := range []constraint.LinearExpression{r1c.L, r1c.R, r1c.O} {
+			terms = iden3Terms(r1cs, expression, terms)
+			w.uint32(uint32(len(terms)))
+			for i := range terms {
+				w.uint32(terms[i].wire)
+				w.element(&terms[i].coeff)
+			}
+		}
+	}
+
+	w.section(r1csLabelSection, 8*uint64(nbWires))
+	for wire := 0; wire < nbWires; wire++ {
+		w.uint64(uint64(wire))
+	}
+	return w.flush()
+}
+
+// WriteSymbols writes the names of the inputs of the circuit of mode in the
+// sym format of circom, one "label,wire,component,name" line per input. The
+// internal wires have no name.
+func WriteSymbols(writer io.Writer, mode string, treeDepth uint32, batchSize uint32) error {
+	var circuit frontend.Circuit
+	switch mode {
+	case insertionMode:
+		circuit = insertionPlaceholder(treeDepth, batchSize)
+	case deletionMode:
+		circuit = deletionPlaceholder(treeDepth, batchSize)
+	default:
+		return fmt.Errorf("invalid mode: %s", mode)
+	}
+	public, secret := inputNames(circuit)
+	w := bufio.NewWriter(writer)
+	for i, name := range append(public, secret...) {
+		// Wire 0 is the constant one.
+		wire := i + 1
+		if _, err := fmt.Fprintf(w, "%d,%d,0,main.%s\n", wire, wire, name); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+var variableType = reflect.TypeOf((*frontend.Variable)(nil)).Elem()
+
+// inputNames returns the names of the public and the secret inputs of the
+// circuit, in the order gnark gives them their wires. The names are the ones
+// of the fields of the circuit, as the gnark tags of the circuits all name
+// their inputs "input".
+func inputNames(circuit frontend.Circuit) (public []string, secret []string) {
+	value := reflect.ValueOf(circuit).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if strings.Contains(field.Tag.Get("gnark"), ",public") {
+			public = appendInputNames(public, field.Name, value.Field(i))
+		} else {
+			secret = appendInputNames(secret, field.Name, value.Field(i))
+		}
+	}
+	return public, secret
+}
+
+func appendInputNames(names []string, name string, value reflect.Value) []string {
+	switch {
+	case value.Type() == variableType:
+		return append(names, name)
+	case value.Kind() == reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			names = appendInputNames(names, fmt.Sprintf("%s[%d]", name, i), value.Index(i))
+		}
+	}
+	return names
+}
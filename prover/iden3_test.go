@@ -0,0 +1,141 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+type TestIden3Circuit struct {
+	Y frontend.Variable `gnark:",public"`
+	X []frontend.Variable
+}
+
+func (circuit *TestIden3Circuit) Define(api frontend.API) error {
+	sum := api.Add(circuit.X[0], 3)
+	for _, x := range circuit.X[1:] {
+		sum = api.Add(sum, api.Mul(x, x, 2))
+	}
+	api.AssertIsEqual(api.Mul(sum, circuit.X[0]), circuit.Y)
+	return nil
+}
+
+// iden3Sections splits a file in the iden3 binary format into its sections.
+func iden3Sections(t *testing.T, data []byte, magic [4]byte) map[uint32][]byte {
+	if !bytes.Equal(data[:4], magic[:]) {
+		t.Fatalf("unexpected magic %q", data[:4])
+	}
+	nbSections := binary.LittleEndian.Uint32(data[8:])
+	data = data[12:]
+	sections := make(map[uint32][]byte)
+	for i := uint32(0); i < nbSections; i++ {
+		sectionType := binary.LittleEndian.Uint32(data)
+		size := binary.LittleEndian.Uint64(data[4:])
+		sections[sectionType] = data[12 : 12+size]
+		data = data[12+size:]
+	}
+	if len(data) != 0 {
+		t.Fatalf("%d bytes after the sections", len(data))
+	}
+	return sections
+}
+
+func readLE(data []byte) *big.Int {
+	return new(big.Int).SetBytes(toBytesLE(append([]byte(nil), data...)))
+}
+
+// The constraints written by WriteR1CSIden3 must be satisfied by the wires
+// written in the wtns format.
+func TestIden3Constraints(t *testing.T) {
+	circuit := TestIden3Circuit{X: make([]frontend.Variable, 3)}
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment := TestIden3Circuit{Y: (5 + 3 + 2*4 + 2*9) * 5, X: []frontend.Variable{5, 2, 3}}
+	ps := ProvingSystem{ConstraintSystem: cs}
+	w, err := ps.newWitness(insertionMode, &assignment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r1csFile, wtnsFile bytes.Buffer
+	if err := WriteR1CSIden3(&r1csFile, cs); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.WriteWitness(&wtnsFile, w, WitnessFormatWtns); err != nil {
+		t.Fatal(err)
+	}
+
+	modulus := ecc.BN254.ScalarField()
+	constraints := iden3Sections(t, r1csFile.Bytes(), r1csMagic)
+	header := constraints[r1csHeaderSection]
+	if readLE(header[4:36]).Cmp(modulus) != 0 {
+		t.Fatalf("unexpected modulus")
+	}
+	nbWires := int(binary.LittleEndian.Uint32(header[36:]))
+	nbPublic := binary.LittleEndian.Uint32(header[44:])
+	nbPrivate := binary.LittleEndian.Uint32(header[48:])
+	nbConstraints := int(binary.LittleEndian.Uint32(header[60:]))
+	if nbPublic != 1 || nbPrivate != 3 || nbConstraints != cs.GetNbConstraints() {
+		t.Fatalf("unexpected header: %d public, %d private, %d constraints", nbPublic, nbPrivate, nbConstraints)
+	}
+
+	wires := iden3Sections(t, wtnsFile.Bytes(), wtnsMagic)[wtnsWiresSection]
+	if len(wires) != nbWires*fpSize {
+		t.Fatalf("%d bytes of wires, expected %d wires", len(wires), nbWires)
+	}
+	values := make([]*big.Int, nbWires)
+	for i := range values {
+		values[i] = readLE(wires[i*fpSize : (i+1)*fpSize])
+	}
+	if values[0].Cmp(big.NewInt(1)) != 0 || values[1].Cmp(big.NewInt(170)) != 0 || values[2].Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("unexpected input wires %s %s %s", values[0], values[1], values[2])
+	}
+
+	data := constraints[r1csConstraintSection]
+	evaluate := func() *big.Int {
+		nbTerms := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		sum := new(big.Int)
+		for i := 0; i < nbTerms; i++ {
+			wire := binary.LittleEndian.Uint32(data)
+			term := readLE(data[4 : 4+fpSize])
+			sum.Add(sum, term.Mul(term, values[wire]))
+			data = data[4+fpSize:]
+		}
+		return sum.Mod(sum, modulus)
+	}
+	for i := 0; i < nbConstraints; i++ {
+		a, b, c := evaluate(), evaluate(), evaluate()
+		if a.Mul(a, b).Mod(a, modulus).Cmp(c) != 0 {
+			t.Fatalf("constraint %d is not satisfied", i)
+		}
+	}
+	if len(data) != 0 {
+		t.Fatalf("%d bytes after the constraints", len(data))
+	}
+}
+
+func TestInputNames(t *testing.T) {
+	public, secret := inputNames(deletionPlaceholder(2, 2))
+	expected := []string{
+		"DeletionIndices[0]", "DeletionIndices[1]", "PreRoot", "PostRoot", "IdComms[0]", "IdComms[1]",
+		"MerkleProofs[0][0]", "MerkleProofs[0][1]", "MerkleProofs[1][0]", "MerkleProofs[1][1]",
+	}
+	if len(public) != 1 || public[0] != "InputHash" {
+		t.Fatalf("unexpected public inputs %v", public)
+	}
+	if len(secret) != len(expected) {
+		t.Fatalf("unexpected secret inputs %v", secret)
+	}
+	for i := range expected {
+		if secret[i] != expected[i] {
+			t.Fatalf("unexpected secret inputs %v", secret)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package prover
+
+import (
+	"encoding/binary"
+	"math/big"
+)
+
+// The input hash of a batch is the keccak hash of its public parameters, each
+// encoded as a fixed-width big-endian number, like abi.encodePacked does with
+// uint32 and uint256 values in Solidity. Field elements are reduced modulo the
+// scalar field first, since that is the value the circuit decomposes into
+// bits.
+
+// fieldBytes returns value as a field element in 32 big-endian bytes, the way
+// the circuit hashes it.
+func fieldBytes(value *big.Int) []byte {
+	return reduced(value).FillBytes(make([]byte, fpSize))
+}
+
+func appendIndex(data []byte, index uint32) []byte {
+	return binary.BigEndian.AppendUint32(data, index)
+}
+
+// InputHashPreimage returns the bytes hashed into the input hash of the
+// insertion circuit: StartIndex in 4 bytes, then PreRoot, PostRoot and the
+// IdComms in 32 bytes each.
+func (p *InsertionParameters) InputHashPreimage() []byte {
+	data := make([]byte, 0, 4+(2+len(p.IdComms))*fpSize)
+	data = appendIndex(data, p.StartIndex)
+	data = append(data, fieldBytes(&p.PreRoot)...)
+	data = append(data, fieldBytes(&p.PostRoot)...)
+	for i := range p.IdComms {
+		data = append(data, fieldBytes(&p.IdComms[i])...)
+	}
+	return data
+}
+
+// InputHashPreimage returns the bytes hashed into the input hash of the
+// deletion circuit: the DeletionIndices in 4 bytes each, then PreRoot and
+// PostRoot in 32 bytes each.
+func (p *DeletionParameters) InputHashPreimage() []byte {
+	data := make([]byte, 0, 4*len(p.DeletionIndices)+2*fpSize)
+	for _, index := range p.DeletionIndices {
+		data = appendIndex(data, index)
+	}
+	data = append(data, fieldBytes(&p.PreRoot)...)
+	data = append(data, fieldBytes(&p.PostRoot)...)
+	return data
+}
@@ -0,0 +1,163 @@
+package prover
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+const preimageTestBatchSize = 3
+
+// Preimage holds the bits of the bytes returned by InputHashPreimage, least
+// significant bit of every byte first, like the circuit feeds them to keccak.
+type TestInsertionPreimageCircuit struct {
+	StartIndex frontend.Variable
+	PreRoot    frontend.Variable
+	PostRoot   frontend.Variable
+	IdComms    [preimageTestBatchSize]frontend.Variable
+	Preimage   []frontend.Variable
+}
+
+func (circuit *TestInsertionPreimageCircuit) Define(api frontend.API) error {
+	mbu := InsertionMbuCircuit{
+		StartIndex: circuit.StartIndex,
+		PreRoot:    circuit.PreRoot,
+		PostRoot:   circuit.PostRoot,
+		IdComms:    circuit.IdComms[:],
+		BatchSize:  preimageTestBatchSize,
+	}
+	assertBitsEqual(api, mbu.inputHashPreimage(api), circuit.Preimage)
+	return nil
+}
+
+type TestDeletionPreimageCircuit struct {
+	DeletionIndices [preimageTestBatchSize]frontend.Variable
+	PreRoot         frontend.Variable
+	PostRoot        frontend.Variable
+	Preimage        []frontend.Variable
+}
+
+func (circuit *TestDeletionPreimageCircuit) Define(api frontend.API) error {
+	mbu := DeletionMbuCircuit{
+		DeletionIndices: circuit.DeletionIndices[:],
+		PreRoot:         circuit.PreRoot,
+		PostRoot:        circuit.PostRoot,
+		BatchSize:       preimageTestBatchSize,
+	}
+	assertBitsEqual(api, mbu.inputHashPreimage(api), circuit.Preimage)
+	return nil
+}
+
+func assertBitsEqual(api frontend.API, actual []frontend.Variable, expected []frontend.Variable) {
+	if len(actual) != len(expected) {
+		panic("preimages of different lengths")
+	}
+	for i := range actual {
+		api.AssertIsEqual(actual[i], expected[i])
+	}
+}
+
+func preimageBits(data []byte) []frontend.Variable {
+	bits := make([]frontend.Variable, 0, 8*len(data))
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			bits = append(bits, (b>>i)&1)
+		}
+	}
+	return bits
+}
+
+// randomField returns a field element, often with leading zero bytes, or one of
+// the edge cases.
+func randomField(rng *rand.Rand) big.Int {
+	modulus := ecc.BN254.ScalarField()
+	var value big.Int
+	switch rng.Intn(6) {
+	case 0:
+	case 1:
+		value.Sub(modulus, big.NewInt(1))
+	case 2, 3:
+		// Between 1 and 31 leading zero bytes.
+		bits := 8 * (1 + rng.Intn(31))
+		value.Rand(rng, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	default:
+		value.Rand(rng, modulus)
+	}
+	return value
+}
+
+func randomIndex(rng *rand.Rand) uint32 {
+	if rng.Intn(2) == 0 {
+		return uint32(rng.Intn(256))
+	}
+	return rng.Uint32()
+}
+
+func TestInsertionInputHashPreimage(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		params := InsertionParameters{
+			StartIndex: randomIndex(rng),
+			PreRoot:    randomField(rng),
+			PostRoot:   randomField(rng),
+			IdComms:    make([]big.Int, preimageTestBatchSize),
+		}
+		assignment := TestInsertionPreimageCircuit{StartIndex: params.StartIndex, PreRoot: params.PreRoot, PostRoot: params.PostRoot}
+		for j := range params.IdComms {
+			params.IdComms[j] = randomField(rng)
+			assignment.IdComms[j] = params.IdComms[j]
+		}
+		preimage := params.InputHashPreimage()
+		if len(preimage) != 4+(2+preimageTestBatchSize)*32 {
+			t.Fatalf("preimage of %d bytes", len(preimage))
+		}
+		assignment.Preimage = preimageBits(preimage)
+		circuit := TestInsertionPreimageCircuit{Preimage: make([]frontend.Variable, len(assignment.Preimage))}
+		err := test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatalf("preimage of %+v does not match the circuit: %s", params, err)
+		}
+	}
+}
+
+func TestDeletionInputHashPreimage(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		params := DeletionParameters{
+			DeletionIndices: make([]uint32, preimageTestBatchSize),
+			PreRoot:         randomField(rng),
+			PostRoot:        randomField(rng),
+		}
+		assignment := TestDeletionPreimageCircuit{PreRoot: params.PreRoot, PostRoot: params.PostRoot}
+		for j := range params.DeletionIndices {
+			params.DeletionIndices[j] = randomIndex(rng)
+			assignment.DeletionIndices[j] = params.DeletionIndices[j]
+		}
+		preimage := params.InputHashPreimage()
+		if len(preimage) != 4*preimageTestBatchSize+2*32 {
+			t.Fatalf("preimage of %d bytes", len(preimage))
+		}
+		assignment.Preimage = preimageBits(preimage)
+		circuit := TestDeletionPreimageCircuit{Preimage: make([]frontend.Variable, len(assignment.Preimage))}
+		err := test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatalf("preimage of %+v does not match the circuit: %s", params, err)
+		}
+	}
+}
+
+// Values outside the field are reduced before being encoded, as they are when
+// assigned to the circuit.
+func TestInputHashPreimageReducesValues(t *testing.T) {
+	modulus := ecc.BN254.ScalarField()
+	params := DeletionParameters{DeletionIndices: []uint32{1}, PostRoot: *big.NewInt(5)}
+	params.PreRoot.Add(modulus, big.NewInt(7))
+	preimage := params.InputHashPreimage()
+	if preimage[4+31] != 7 || preimage[4+63] != 5 {
+		t.Fatalf("unexpected preimage %x", preimage)
+	}
+}
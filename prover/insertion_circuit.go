@@ -28,28 +28,8 @@ type InsertionMbuCircuit struct {
 
 func (circuit *InsertionMbuCircuit) Define(api frontend.API) error {
 	// Hash private inputs.
-	// We keccak hash all input to save verification gas. Inputs are arranged as follows:
-	// StartIndex || PreRoot || PostRoot || IdComms[0] || IdComms[1] || ... || IdComms[batchSize-1]
-	//     32	  ||   256   ||   256    ||    256     ||    256     || ... ||     256 bits
-	var bits []frontend.Variable
-
-	// We convert all the inputs to the keccak hash to use big-endian (network) byte
-	// ordering so that it agrees with Solidity. This ensures that we don't have to
-	// perform the conversion inside the contract and hence save on gas.
-	bits_start := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.StartIndex, Size: 32})
-	bits = append(bits, bits_start...)
-
-	bits_pre := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PreRoot, Size: 256})
-	bits = append(bits, bits_pre...)
-
-	bits_post := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PostRoot, Size: 256})
-	bits = append(bits, bits_post...)
-
-	for i := 0; i < circuit.BatchSize; i++ {
-		bits_id := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.IdComms[i], Size: 256})
-		bits = append(bits, bits_id...)
-	}
-
+	// We keccak hash all input to save verification gas.
+	bits := circuit.inputHashPreimage(api)
 	hash := keccak.NewKeccak256(api, (circuit.BatchSize+2)*256+32, bits...)
 	sum := abstractor.Call(api, FromBinaryBigEndian{Variable: hash})
 
@@ -75,6 +55,33 @@ func (circuit *InsertionMbuCircuit) Define(api frontend.API) error {
 	return nil
 }
 
+// inputHashPreimage returns the bits hashed into InputHash, matching the bytes
+// of InsertionParameters.InputHashPreimage.
+func (circuit *InsertionMbuCircuit) inputHashPreimage(api frontend.API) []frontend.Variable {
+	// Inputs are arranged as follows:
+	// StartIndex || PreRoot || PostRoot || IdComms[0] || IdComms[1] || ... || IdComms[batchSize-1]
+	//     32	  ||   256   ||   256    ||    256     ||    256     || ... ||     256 bits
+	var bits []frontend.Variable
+
+	// We convert all the inputs to the keccak hash to use big-endian (network) byte
+	// ordering so that it agrees with Solidity. This ensures that we don't have to
+	// perform the conversion inside the contract and hence save on gas.
+	bits_start := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.StartIndex, Size: 32})
+	bits = append(bits, bits_start...)
+
+	bits_pre := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PreRoot, Size: 256})
+	bits = append(bits, bits_pre...)
+
+	bits_post := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.PostRoot, Size: 256})
+	bits = append(bits, bits_post...)
+
+	for i := 0; i < circuit.BatchSize; i++ {
+		bits_id := abstractor.Call1(api, ToReducedBigEndian{Variable: circuit.IdComms[i], Size: 256})
+		bits = append(bits, bits_id...)
+	}
+	return bits
+}
+
 func ImportInsertionSetup(treeDepth uint32, batchSize uint32, pkPath string, vkPath string) (*ProvingSystem, error) {
 	proofs := make([][]frontend.Variable, batchSize)
 	for i := 0; i < int(batchSize); i++ {
@@ -1,11 +1,9 @@
 package prover
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"math/big"
-	"worldcoin/gnark-mbu/logging"
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
@@ -22,12 +20,24 @@ type InsertionParameters struct {
 	PostRoot     big.Int
 	IdComms      []big.Int
 	MerkleProofs [][]big.Int
+	// StartPath can be set instead of MerkleProofs, which are then derived
+	// from it.
+	StartPath []big.Int
 }
 
 func (p *InsertionParameters) ValidateShape(treeDepth uint32, batchSize uint32) error {
 	if len(p.IdComms) != int(batchSize) {
 		return fmt.Errorf("wrong number of identity commitments: %d", len(p.IdComms))
 	}
+	if len(p.StartPath) > 0 {
+		if len(p.MerkleProofs) > 0 {
+			return fmt.Errorf("both merkle proofs and a start path given")
+		}
+		if len(p.StartPath) != int(treeDepth) {
+			return fmt.Errorf("wrong size of start path: %d", len(p.StartPath))
+		}
+		return nil
+	}
 	if len(p.MerkleProofs) != int(batchSize) {
 		return fmt.Errorf("wrong number of merkle proofs: %d", len(p.MerkleProofs))
 	}
@@ -44,41 +54,28 @@ func (p *InsertionParameters) ValidateShape(treeDepth uint32, batchSize uint32)
 // It uses big-endian byte ordering (network ordering) in order to agree with
 // Solidity and avoid the need to perform the byte swapping operations on-chain
 // where they would increase our gas cost.
-func (p *InsertionParameters) ComputeInputHashInsertion() error {
-	var data []byte
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, p.StartIndex)
-	if err != nil {
-		return err
-	}
-	data = append(data, buf.Bytes()...)
-	data = append(data, p.PreRoot.Bytes()...)
-	data = append(data, p.PostRoot.Bytes()...)
-	for _, v := range p.IdComms {
-		idBytes := v.Bytes()
-		// extend to 32 bytes if necessary, maintaining big-endian ordering
-		if len(idBytes) < 32 {
-			idBytes = append(make([]byte, 32-len(idBytes)), idBytes...)
-		}
-		data = append(data, idBytes...)
-	}
-	hashBytes := keccak256.Hash(data)
+func (p *InsertionParameters) ComputeInputHashInsertion() {
+	hashBytes := keccak256.Hash(p.InputHashPreimage())
 	p.InputHash.SetBytes(hashBytes)
-	return nil
 }
 
 func BuildR1CSInsertion(treeDepth uint32, batchSize uint32) (constraint.ConstraintSystem, error) {
+	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, insertionPlaceholder(treeDepth, batchSize))
+}
+
+// insertionPlaceholder returns the circuit to compile for the tree depth and
+// batch size.
+func insertionPlaceholder(treeDepth uint32, batchSize uint32) *InsertionMbuCircuit {
 	proofs := make([][]frontend.Variable, batchSize)
 	for i := 0; i < int(batchSize); i++ {
 		proofs[i] = make([]frontend.Variable, treeDepth)
 	}
-	circuit := InsertionMbuCircuit{
+	return &InsertionMbuCircuit{
 		Depth:        int(treeDepth),
 		BatchSize:    int(batchSize),
 		IdComms:      make([]frontend.Variable, batchSize),
 		MerkleProofs: proofs,
 	}
-	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
 }
 
 func SetupInsertion(treeDepth uint32, batchSize uint32) (*ProvingSystem, error) {
@@ -94,9 +91,45 @@ func SetupInsertion(treeDepth uint32, batchSize uint32) (*ProvingSystem, error)
 }
 
 func (ps *ProvingSystem) ProveInsertion(params *InsertionParameters) (*Proof, error) {
+	return ps.ProveInsertionContext(context.Background(), params)
+}
+
+// ProveInsertionContext is like ProveInsertion, but gives up on the proof once ctx is
+// done, returning the error of the context.
+func (ps *ProvingSystem) ProveInsertionContext(ctx context.Context, params *InsertionParameters) (*Proof, error) {
 	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
 		return nil, err
 	}
+	merkleProofs, err := params.merkleProofs()
+	if err != nil {
+		return nil, err
+	}
+	if err := params.validate(merkleProofs); err != nil {
+		return nil, err
+	}
+	proof, err := ps.prove(ctx, insertionMode, ps.insertionAssignment(params, merkleProofs))
+	if err != nil {
+		return nil, err
+	}
+	proof.InputHash = new(big.Int).Set(&params.InputHash)
+	return proof, nil
+}
+
+// InsertionWitness builds the witness of the insertion circuit for the
+// parameters. Apart from their shape, the parameters are not validated, so
+// that the witness of a failing batch can be captured.
+func (ps *ProvingSystem) InsertionWitness(params *InsertionParameters) (*Witness, error) {
+	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
+		return nil, err
+	}
+	merkleProofs, err := params.merkleProofs()
+	if err != nil {
+		return nil, err
+	}
+	return ps.newWitness(insertionMode, ps.insertionAssignment(params, merkleProofs))
+}
+
+func (ps *ProvingSystem) insertionAssignment(params *InsertionParameters, merkleProofs [][]big.Int) *InsertionMbuCircuit {
 	idComms := make([]frontend.Variable, ps.BatchSize)
 	for i := 0; i < int(ps.BatchSize); i++ {
 		idComms[i] = params.IdComms[i]
@@ -105,10 +138,10 @@ func (ps *ProvingSystem) ProveInsertion(params *InsertionParameters) (*Proof, er
 	for i := 0; i < int(ps.BatchSize); i++ {
 		proofs[i] = make([]frontend.Variable, ps.TreeDepth)
 		for j := 0; j < int(ps.TreeDepth); j++ {
-			proofs[i][j] = params.MerkleProofs[i][j]
+			proofs[i][j] = merkleProofs[i][j]
 		}
 	}
-	assignment := InsertionMbuCircuit{
+	return &InsertionMbuCircuit{
 		InputHash:    params.InputHash,
 		StartIndex:   params.StartIndex,
 		PreRoot:      params.PreRoot,
@@ -116,17 +149,6 @@ func (ps *ProvingSystem) ProveInsertion(params *InsertionParameters) (*Proof, er
 		IdComms:      idComms,
 		MerkleProofs: proofs,
 	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		return nil, err
-	}
-	logging.Logger().Info().Msg("generating proof")
-	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
-	if err != nil {
-		return nil, err
-	}
-	logging.Logger().Info().Msg("proof generated successfully")
-	return &Proof{proof}, nil
 }
 
 func (ps *ProvingSystem) VerifyInsertion(inputHash big.Int, proof *Proof) error {
@@ -134,9 +156,5 @@ func (ps *ProvingSystem) VerifyInsertion(inputHash big.Int, proof *Proof) error
 		InputHash: inputHash,
 		IdComms:   make([]frontend.Variable, ps.BatchSize),
 	}
-	witness, err := frontend.NewWitness(&publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
-	if err != nil {
-		return err
-	}
-	return groth16.Verify(proof.Proof, ps.VerifyingKey, witness)
+	return ps.verify(insertionMode, &publicAssignment, proof)
 }
@@ -0,0 +1,217 @@
+package prover
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// KeysFileInfo describes a keys file without loading it.
+type KeysFileInfo struct {
+	// Mode is told from the secret inputs of the circuit, it is empty for a
+	// circuit of neither mode.
+	Mode        string `json:"mode"`
+	TreeDepth   uint32 `json:"treeDepth"`
+	BatchSize   uint32 `json:"batchSize"`
+	Curve       string `json:"curve"`
+	Backend     string `json:"backend"`
+	Constraints int    `json:"constraints"`
+	// The public wires include the constant wire.
+	PublicWires   int               `json:"publicWires"`
+	SecretWires   int               `json:"secretWires"`
+	InternalWires int               `json:"internalWires"`
+	Sections      []KeysFileSection `json:"sections"`
+	// VerifyingKeyHash is the hash returned by VerifyingKeyHash.
+	VerifyingKeyHash string `json:"verifyingKeyHash"`
+	// SHA256 is the hash of the whole file.
+	SHA256 string `json:"sha256"`
+}
+
+// KeysFileSection is a part of a keys file, holding points of a key or the
+// constraint system.
+type KeysFileSection struct {
+	Name   string `json:"name"`
+	Points uint64 `json:"points,omitempty"`
+	Size   int64  `json:"size"`
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// InspectKeysFile describes the keys file at path. Unlike ReadSystemFromFile,
+// it skips the points of the proving key and the constraints instead of
+// decoding them, so it takes about the time needed to hash the file.
+func InspectKeysFile(path string) (*KeysFileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole file goes through the hash, whether it is decoded or not.
+	hash := sha256.New()
+	r := &countingReader{reader: bufio.NewReaderSize(io.TeeReader(file, hash), 1<<20)}
+	info := KeysFileInfo{Curve: ecc.BN254.String(), Backend: "groth16"}
+	section := func(name string, points uint64, start int64) {
+		info.Sections = append(info.Sections, KeysFileSection{Name: name, Points: points, Size: r.read - start})
+	}
+	var buf [8]byte
+	readUint32 := func() (uint32, error) {
+		_, err := io.ReadFull(r, buf[:4])
+		return binary.BigEndian.Uint32(buf[:4]), err
+	}
+	readUint64 := func() (uint64, error) {
+		_, err := io.ReadFull(r, buf[:8])
+		return binary.BigEndian.Uint64(buf[:8]), err
+	}
+	skip := func(n uint64) error {
+		_, err := io.CopyN(io.Discard, r, int64(n))
+		return err
+	}
+	// points skips a slice of points of the given size, returning its length.
+	points := func(size uint64) (uint64, error) {
+		n, err := readUint32()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(n), skip(uint64(n) * size)
+	}
+
+	if info.TreeDepth, err = readUint32(); err != nil {
+		return nil, err
+	}
+	if info.BatchSize, err = readUint32(); err != nil {
+		return nil, err
+	}
+	section("header", 0, 0)
+
+	// The proving key is written by gnark with compressed points, see
+	// ProvingKey.WriteTo: its domain, [α]1, [β]1, [δ]1, A, B, Z and K in G1,
+	// [β]2, [δ]2 and B in G2, then the number of wires, the number of points
+	// at infinity in A and B, and the flags of the wires at infinity.
+	start := r.read
+	if err := skip(8 + 5*fr.Bytes); err != nil {
+		return nil, fmt.Errorf("truncated proving key: %w", err)
+	}
+	section("pk.domain", 0, start)
+	start = r.read
+	if err := skip(3 * bn254.SizeOfG1AffineCompressed); err != nil {
+		return nil, fmt.Errorf("truncated proving key: %w", err)
+	}
+	section("pk.G1", 3, start)
+	for _, name := range []string{"pk.A", "pk.B1", "pk.Z", "pk.K"} {
+		start = r.read
+		n, err := points(bn254.SizeOfG1AffineCompressed)
+		if err != nil {
+			return nil, fmt.Errorf("truncated proving key: %w", err)
+		}
+		section(name, n, start)
+	}
+	start = r.read
+	if err := skip(2 * bn254.SizeOfG2AffineCompressed); err != nil {
+		return nil, fmt.Errorf("truncated proving key: %w", err)
+	}
+	section("pk.G2", 2, start)
+	start = r.read
+	n, err := points(bn254.SizeOfG2AffineCompressed)
+	if err != nil {
+		return nil, fmt.Errorf("truncated proving key: %w", err)
+	}
+	section("pk.B2", n, start)
+	start = r.read
+	nbWires, err := readUint64()
+	if err == nil {
+		err = skip(16 + 2*nbWires)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated proving key: %w", err)
+	}
+	section("pk.infinity", 0, start)
+
+	// The verifying key is small enough to be hashed on its own: [α]1, [β]1,
+	// [β]2, [γ]2, [δ]1, [δ]2 and K.
+	start = r.read
+	vk := make([]byte, 3*bn254.SizeOfG1AffineCompressed+3*bn254.SizeOfG2AffineCompressed+4)
+	if _, err := io.ReadFull(r, vk); err != nil {
+		return nil, fmt.Errorf("truncated verifying key: %w", err)
+	}
+	nbK := binary.BigEndian.Uint32(vk[len(vk)-4:])
+	k := make([]byte, uint64(nbK)*bn254.SizeOfG1AffineCompressed)
+	if _, err := io.ReadFull(r, k); err != nil {
+		return nil, fmt.Errorf("truncated verifying key: %w", err)
+	}
+	vkHash := sha256.Sum256(append(vk, k...))
+	info.VerifyingKeyHash = hex.EncodeToString(vkHash[:])
+	section("vk", uint64(nbK), start)
+
+	// Only the fields needed of the constraint system are decoded, the
+	// constraints are skipped over.
+	start = r.read
+	var ccs struct {
+		NbInternalVariables int
+		Public, Secret      []string
+		Constraints         []struct{}
+	}
+	dm, err := cbor.DecOptions{MaxArrayElements: 134217728, MaxMapPairs: 134217728}.DecMode()
+	if err != nil {
+		return nil, err
+	}
+	if err := dm.NewDecoder(r).Decode(&ccs); err != nil {
+		return nil, fmt.Errorf("invalid constraint system: %w", err)
+	}
+	info.Constraints = len(ccs.Constraints)
+	info.PublicWires = len(ccs.Public)
+	info.SecretWires = len(ccs.Secret)
+	info.InternalWires = ccs.NbInternalVariables
+	info.Mode = circuitMode(ccs.Secret, info.TreeDepth, info.BatchSize)
+	// The decoder reads ahead, the constraint system ends the file.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	if r.read != stat.Size() {
+		return nil, fmt.Errorf("keys file changed while being read")
+	}
+	section("r1cs", 0, start)
+
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return &info, nil
+}
+
+// circuitMode tells the mode of a circuit from its secret inputs, which are
+// all named after their "input" tag: the insertion circuit starts with the
+// start index, and the deletion circuit with the slice of deletion indices.
+func circuitMode(secret []string, treeDepth uint32, batchSize uint32) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	depth, batch := int(treeDepth), int(batchSize)
+	if secret[0] == "input" && len(secret) == 3+batch+batch*depth {
+		return insertionMode
+	}
+	if secret[0] == "input_0" && len(secret) == 2+2*batch+batch*depth {
+		return deletionMode
+	}
+	return ""
+}
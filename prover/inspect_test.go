@@ -0,0 +1,94 @@
+package prover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// writeTestKeysFile writes a keys file for TestIden3Circuit, with a tree depth
+// of 4 and a batch size of 5.
+func writeTestKeysFile(t *testing.T) (string, *ProvingSystem) {
+	ccs := compileIden3Circuit(t, 3)
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := ProvingSystem{TreeDepth: 4, BatchSize: 5, ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: ccs}
+	path := filepath.Join(t.TempDir(), "keys")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, &ps
+}
+
+func TestInspectKeysFile(t *testing.T) {
+	path, ps := writeTestKeysFile(t)
+	ccs := ps.ConstraintSystem
+	info, err := InspectKeysFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TreeDepth != 4 || info.BatchSize != 5 || info.Mode != "" {
+		t.Fatalf("unexpected shape: %+v", info)
+	}
+	if info.Constraints != ccs.GetNbConstraints() || info.PublicWires != ccs.GetNbPublicVariables() ||
+		info.SecretWires != ccs.GetNbSecretVariables() || info.InternalWires != ccs.GetNbInternalVariables() {
+		t.Fatalf("unexpected constraint system: %+v", info)
+	}
+	vkHash, err := ps.VerifyingKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VerifyingKeyHash != hex.EncodeToString(vkHash) {
+		t.Fatalf("unexpected verifying key hash: %s", info.VerifyingKeyHash)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileHash := sha256.Sum256(data)
+	if info.SHA256 != hex.EncodeToString(fileHash[:]) {
+		t.Fatalf("unexpected file hash: %s", info.SHA256)
+	}
+	size := int64(0)
+	for _, section := range info.Sections {
+		size += section.Size
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("sections add up to %d bytes, the file has %d", size, len(data))
+	}
+
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InspectKeysFile(path); err == nil {
+		t.Fatal("inspected a truncated keys file")
+	}
+}
+
+func TestCircuitMode(t *testing.T) {
+	for _, mode := range []string{insertionMode, deletionMode} {
+		r1cs, err := buildR1CS(mode, 2, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := circuitMode(r1cs.Secret, 2, 3); got != mode {
+			t.Fatalf("circuit of %s mode told as %q", mode, got)
+		}
+		if got := circuitMode(r1cs.Secret, 3, 3); got != "" {
+			t.Fatalf("circuit of %s mode told as %q for another depth", mode, got)
+		}
+	}
+}
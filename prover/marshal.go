@@ -8,19 +8,96 @@ import (
 	"io"
 	"math/big"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
 )
 
-func fromHex(i *big.Int, s string) error {
-	_, ok := i.SetString(s, 0)
-	if !ok {
-		return fmt.Errorf("invalid number: %s", s)
+// Kinds of FieldElementError.
+const (
+	// MalformedNumber means that the value is neither a 0x-prefixed
+	// hexadecimal nor a decimal number.
+	MalformedNumber = "malformed"
+	// NegativeNumber means that the value has a minus sign.
+	NegativeNumber = "negative"
+	// NumberOutOfRange means that the value is not below the modulus of its
+	// field, or 2^256 for input hashes.
+	NumberOutOfRange = "out_of_range"
+)
+
+// FieldElementError reports a number of the JSON encoding that is not a valid
+// field element, along with its JSON path, such as merkleProofs[1][0]. For the
+// binary encoding, the path is the position of the value, such as value 3.
+type FieldElementError struct {
+	Kind  string
+	Path  string
+	Value string
+}
+
+func (err *FieldElementError) Error() string {
+	switch err.Kind {
+	case MalformedNumber:
+		return fmt.Sprintf("%s is not a 0x-prefixed hexadecimal or decimal number: %q", err.Path, err.Value)
+	case NegativeNumber:
+		return fmt.Sprintf("%s is negative: %q", err.Path, err.Value)
+	}
+	return fmt.Sprintf("%s is out of range: %q", err.Path, err.Value)
+}
+
+var (
+	scalarBound    = ecc.BN254.ScalarField()
+	baseBound      = ecc.BN254.BaseField()
+	inputHashBound = new(big.Int).Lsh(big.NewInt(1), 8*fpSize)
+)
+
+// parseNumber parses s, a 0x-prefixed hexadecimal or decimal number, which
+// must be below bound.
+func parseNumber(i *big.Int, s string, path string, bound *big.Int) error {
+	unsigned := strings.TrimPrefix(s, "-")
+	digits, base := unsigned, 10
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0X") {
+		digits, base = unsigned[2:], 16
+	}
+	if !isDigits(digits, base) {
+		return &FieldElementError{Kind: MalformedNumber, Path: path, Value: s}
+	}
+	if unsigned != s {
+		return &FieldElementError{Kind: NegativeNumber, Path: path, Value: s}
+	}
+	i.SetString(digits, base)
+	if i.Cmp(bound) >= 0 {
+		return &FieldElementError{Kind: NumberOutOfRange, Path: path, Value: s}
 	}
 	return nil
 }
 
+func isDigits(s string, base int) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		isDecimal := '0' <= c && c <= '9'
+		isHex := 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+		if !isDecimal && !(base == 16 && isHex) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseField parses an element of the scalar field.
+func parseField(i *big.Int, s string, path string) error {
+	return parseNumber(i, s, path, scalarBound)
+}
+
+// ParseInputHash parses an input hash, which can be any 256-bit number since
+// it is only reduced to a field element in the circuit.
+func ParseInputHash(i *big.Int, s string, path string) error {
+	return parseNumber(i, s, path, inputHashBound)
+}
+
 func toHex(i *big.Int) string {
 	return fmt.Sprintf("0x%s", i.Text(16))
 }
@@ -31,7 +108,8 @@ type InsertionParametersJSON struct {
 	PreRoot      string     `json:"preRoot"`
 	PostRoot     string     `json:"postRoot"`
 	IdComms      []string   `json:"identityCommitments"`
-	MerkleProofs [][]string `json:"merkleProofs"`
+	MerkleProofs [][]string `json:"merkleProofs,omitempty"`
+	StartPath    []string   `json:"startPath,omitempty"`
 }
 type DeletionParametersJSON struct {
 	InputHash       string     `json:"inputHash"`
@@ -59,6 +137,12 @@ func (p *InsertionParameters) MarshalJSON() ([]byte, error) {
 			paramsJson.MerkleProofs[i][j] = toHex(&p.MerkleProofs[i][j])
 		}
 	}
+	if len(p.StartPath) > 0 {
+		paramsJson.StartPath = make([]string, len(p.StartPath))
+		for i := 0; i < len(p.StartPath); i++ {
+			paramsJson.StartPath[i] = toHex(&p.StartPath[i])
+		}
+	}
 	return json.Marshal(paramsJson)
 }
 
@@ -71,26 +155,28 @@ func (p *InsertionParameters) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	err = fromHex(&p.InputHash, params.InputHash)
-	if err != nil {
-		return err
+	if params.InputHash != "" {
+		err = ParseInputHash(&p.InputHash, params.InputHash, "inputHash")
+		if err != nil {
+			return err
+		}
 	}
 
 	p.StartIndex = params.StartIndex
 
-	err = fromHex(&p.PreRoot, params.PreRoot)
+	err = parseField(&p.PreRoot, params.PreRoot, "preRoot")
 	if err != nil {
 		return err
 	}
 
-	err = fromHex(&p.PostRoot, params.PostRoot)
+	err = parseField(&p.PostRoot, params.PostRoot, "postRoot")
 	if err != nil {
 		return err
 	}
 
 	p.IdComms = make([]big.Int, len(params.IdComms))
 	for i := 0; i < len(params.IdComms); i++ {
-		err = fromHex(&p.IdComms[i], params.IdComms[i])
+		err = parseField(&p.IdComms[i], params.IdComms[i], fmt.Sprintf("identityCommitments[%d]", i))
 		if err != nil {
 			return err
 		}
@@ -100,13 +186,24 @@ func (p *InsertionParameters) UnmarshalJSON(data []byte) error {
 	for i := 0; i < len(params.MerkleProofs); i++ {
 		p.MerkleProofs[i] = make([]big.Int, len(params.MerkleProofs[i]))
 		for j := 0; j < len(params.MerkleProofs[i]); j++ {
-			err = fromHex(&p.MerkleProofs[i][j], params.MerkleProofs[i][j])
+			err = parseField(&p.MerkleProofs[i][j], params.MerkleProofs[i][j], fmt.Sprintf("merkleProofs[%d][%d]", i, j))
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	p.StartPath = make([]big.Int, len(params.StartPath))
+	for i := 0; i < len(params.StartPath); i++ {
+		err = parseField(&p.StartPath[i], params.StartPath[i], fmt.Sprintf("startPath[%d]", i))
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.InputHash == "" {
+		p.ComputeInputHashInsertion()
+	}
 	return nil
 }
 
@@ -139,26 +236,28 @@ func (p *DeletionParameters) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	err = fromHex(&p.InputHash, params.InputHash)
-	if err != nil {
-		return err
+	if params.InputHash != "" {
+		err = ParseInputHash(&p.InputHash, params.InputHash, "inputHash")
+		if err != nil {
+			return err
+		}
 	}
 
 	p.DeletionIndices = params.DeletionIndices
 
-	err = fromHex(&p.PreRoot, params.PreRoot)
+	err = parseField(&p.PreRoot, params.PreRoot, "preRoot")
 	if err != nil {
 		return err
 	}
 
-	err = fromHex(&p.PostRoot, params.PostRoot)
+	err = parseField(&p.PostRoot, params.PostRoot, "postRoot")
 	if err != nil {
 		return err
 	}
 
 	p.IdComms = make([]big.Int, len(params.IdComms))
 	for i := 0; i < len(params.IdComms); i++ {
-		err = fromHex(&p.IdComms[i], params.IdComms[i])
+		err = parseField(&p.IdComms[i], params.IdComms[i], fmt.Sprintf("identityCommitments[%d]", i))
 		if err != nil {
 			return err
 		}
@@ -168,42 +267,82 @@ func (p *DeletionParameters) UnmarshalJSON(data []byte) error {
 	for i := 0; i < len(params.MerkleProofs); i++ {
 		p.MerkleProofs[i] = make([]big.Int, len(params.MerkleProofs[i]))
 		for j := 0; j < len(params.MerkleProofs[i]); j++ {
-			err = fromHex(&p.MerkleProofs[i][j], params.MerkleProofs[i][j])
+			err = parseField(&p.MerkleProofs[i][j], params.MerkleProofs[i][j], fmt.Sprintf("merkleProofs[%d][%d]", i, j))
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	if params.InputHash == "" {
+		p.ComputeInputHashDeletion()
+	}
 	return nil
 }
 
 type ProofJSON struct {
-	Ar  [2]string    `json:"ar"`
-	Bs  [2][2]string `json:"bs"`
-	Krs [2]string    `json:"krs"`
+	Ar        [2]string    `json:"ar"`
+	Bs        [2][2]string `json:"bs"`
+	Krs       [2]string    `json:"krs"`
+	InputHash string       `json:"inputHash,omitempty"`
 }
 
-func (p *Proof) MarshalJSON() ([]byte, error) {
-	const fpSize = 32
+const fpSize = 32
+
+// Elements returns the coordinates of the proof points in the order Ar, Bs
+// (row by row) and Krs.
+func (p *Proof) Elements() ([8]big.Int, error) {
+	var elements [8]big.Int
 	var buf bytes.Buffer
 	_, err := p.Proof.WriteRawTo(&buf)
 	if err != nil {
-		return nil, err
+		return elements, err
 	}
 	proofBytes := buf.Bytes()
-	proofJson := ProofJSON{}
+	for i := 0; i < 8; i++ {
+		elements[i].SetBytes(proofBytes[i*fpSize : (i+1)*fpSize])
+	}
+	return elements, nil
+}
+
+// ProofFromElements is the inverse of Elements.
+func ProofFromElements(elements [8]big.Int) (*Proof, error) {
+	proofBytes := make([]byte, 8*fpSize)
+	for i := 0; i < 8; i++ {
+		if elements[i].Sign() < 0 || elements[i].BitLen() > 8*fpSize {
+			return nil, fmt.Errorf("invalid proof element: %s", toHex(&elements[i]))
+		}
+		elements[i].FillBytes(proofBytes[i*fpSize : (i+1)*fpSize])
+	}
+
+	proof := groth16.NewProof(ecc.BN254)
+	_, err := proof.ReadFrom(bytes.NewReader(proofBytes))
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{Proof: proof}, nil
+}
+
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	elements, err := p.Elements()
+	if err != nil {
+		return nil, err
+	}
 	proofHexNumbers := [8]string{}
 	for i := 0; i < 8; i++ {
-		proofHexNumbers[i] = toHex(new(big.Int).SetBytes(proofBytes[i*fpSize : (i+1)*fpSize]))
+		proofHexNumbers[i] = toHex(&elements[i])
 	}
 
+	proofJson := ProofJSON{}
 	proofJson.Ar = [2]string{proofHexNumbers[0], proofHexNumbers[1]}
 	proofJson.Bs = [2][2]string{
 		{proofHexNumbers[2], proofHexNumbers[3]},
 		{proofHexNumbers[4], proofHexNumbers[5]},
 	}
 	proofJson.Krs = [2]string{proofHexNumbers[6], proofHexNumbers[7]}
+	if p.InputHash != nil {
+		proofJson.InputHash = toHex(p.InputHash)
+	}
 
 	return json.Marshal(proofJson)
 }
@@ -224,25 +363,28 @@ func (p *Proof) UnmarshalJSON(data []byte) error {
 		proofJson.Krs[0],
 		proofJson.Krs[1],
 	}
+	proofPaths := [8]string{"ar[0]", "ar[1]", "bs[0][0]", "bs[0][1]", "bs[1][0]", "bs[1][1]", "krs[0]", "krs[1]"}
 	proofInts := [8]big.Int{}
 	for i := 0; i < 8; i++ {
-		err = fromHex(&proofInts[i], proofHexNumbers[i])
+		// The coordinates of the points are elements of the base field.
+		err = parseNumber(&proofInts[i], proofHexNumbers[i], proofPaths[i], baseBound)
 		if err != nil {
 			return err
 		}
 	}
-	const fpSize = 32
-	proofBytes := make([]byte, 8*fpSize)
-	for i := 0; i < 8; i++ {
-		copy(proofBytes[i*fpSize:(i+1)*fpSize], proofInts[i].Bytes())
-	}
-
-	p.Proof = groth16.NewProof(ecc.BN254)
-
-	_, err = p.Proof.ReadFrom(bytes.NewReader(proofBytes))
+	proof, err := ProofFromElements(proofInts)
 	if err != nil {
 		return err
 	}
+	p.Proof = proof.Proof
+	p.InputHash = nil
+	if proofJson.InputHash != "" {
+		p.InputHash = new(big.Int)
+		err = ParseInputHash(p.InputHash, proofJson.InputHash, "inputHash")
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -328,7 +470,7 @@ func (ps *ProvingSystem) UnsafeReadFrom(r io.Reader) (int64, error) {
 }
 
 func ReadSystemFromFile(path string) (ps *ProvingSystem, err error) {
-	ps = new(ProvingSystem)
+	start := time.Now()
 	file, err := os.Open(path)
 	if err != nil {
 		return
@@ -341,9 +483,23 @@ func ReadSystemFromFile(path string) (ps *ProvingSystem, err error) {
 		}
 	}()
 
-	_, err = ps.UnsafeReadFrom(file)
+	return readSystem(file, start)
+}
+
+// readSystem reads the keys file from its current position, recording the
+// time since start as its loading time.
+func readSystem(file *os.File, start time.Time) (*ProvingSystem, error) {
+	info, err := file.Stat()
 	if err != nil {
-		return
+		return nil, err
+	}
+	ps := new(ProvingSystem)
+	keysLoadProgress.Set(0)
+	read, err := ps.UnsafeReadFrom(&progressReader{reader: file, total: info.Size()})
+	if err != nil {
+		return nil, err
 	}
-	return
+	keysLoadDuration.Set(time.Since(start).Seconds())
+	keysSize.Set(float64(read))
+	return ps, nil
 }
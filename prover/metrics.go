@@ -0,0 +1,82 @@
+package prover
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const insertionMode = "insertion"
+const deletionMode = "deletion"
+
+var durationBuckets = prometheus.ExponentialBuckets(0.01, 2, 18)
+
+var (
+	witnessDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mtb",
+		Name:      "witness_duration_seconds",
+		Help:      "Time spent building witnesses.",
+		Buckets:   durationBuckets,
+	}, []string{"mode", "batch_size"})
+	provingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mtb",
+		Name:      "proving_duration_seconds",
+		Help:      "Time spent generating proofs.",
+		Buckets:   durationBuckets,
+	}, []string{"mode", "batch_size"})
+	verifyingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mtb",
+		Name:      "verifying_duration_seconds",
+		Help:      "Time spent verifying proofs.",
+		Buckets:   durationBuckets,
+	}, []string{"mode", "batch_size"})
+	proofsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "proofs_in_flight",
+		Help:      "Number of proofs currently being generated.",
+	})
+	proofsAbandoned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mtb",
+		Name:      "proofs_abandoned_total",
+		Help:      "Number of proofs given up on because they were cancelled or their deadline expired.",
+	}, []string{"mode"})
+	keysLoadDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "keys_load_duration_seconds",
+		Help:      "Time it took to read the proving system from file.",
+	})
+	keysSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "keys_size_bytes",
+		Help:      "Size of the proving system read from file.",
+	})
+	keysLoadProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "keys_load_progress_ratio",
+		Help:      "Fraction of the keys file read so far.",
+	})
+)
+
+func observeDuration(histogram *prometheus.HistogramVec, mode string, batchSize uint32, start time.Time) {
+	histogram.WithLabelValues(mode, strconv.FormatUint(uint64(batchSize), 10)).Observe(time.Since(start).Seconds())
+}
+
+// progressReader reports the fraction of the underlying reader consumed so far
+// on the keys loading progress gauge.
+type progressReader struct {
+	reader io.Reader
+	read   int64
+	total  int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.total > 0 {
+		keysLoadProgress.Set(float64(r.read) / float64(r.total))
+	}
+	return n, err
+}
@@ -0,0 +1,115 @@
+package prover
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+)
+
+// A ptau file holds the powers of tau of a phase-1 ceremony, such as the ones
+// of the perpetual powers of tau, in the layout described in iden3.go. For a
+// ceremony of power p, the sections read are:
+//
+//	section 1:  field size 32, the modulus of the base field and p
+//	section 2:  [τ^i]1 for i below 2^(p+1) - 1
+//	section 4:  [α·τ^i]1 for i below 2^p
+//	section 5:  [β·τ^i]1 for i below 2^p
+//	section 6:  [β]2
+//	section 12: [L_i(τ)]1 for the Lagrange polynomials L_i of every domain
+//	            of size 2^k, for k from 0 to p, one domain after the other
+//	section 13: [L_i(τ)]2 for the same polynomials
+//	section 14: [α·L_i(τ)]1 for the same polynomials
+//	section 15: [β·L_i(τ)]1 for the same polynomials
+//
+// The sections of the Lagrange polynomials are added by `snarkjs powersoftau
+// prepare phase2`. The file is expected to be verified already, with `snarkjs
+// powersoftau verify`, its points are only checked to be on the curve.
+var ptauMagic = [4]byte{'p', 't', 'a', 'u'}
+
+const (
+	ptauVersion                = 1
+	ptauHeaderSection          = 1
+	ptauTauG1Section           = 2
+	ptauAlphaTauG1Section      = 4
+	ptauBetaTauG1Section       = 5
+	ptauBetaG2Section          = 6
+	ptauLagrangeG1Section      = 12
+	ptauLagrangeG2Section      = 13
+	ptauAlphaLagrangeG1Section = 14
+	ptauBetaLagrangeG1Section  = 15
+)
+
+// phase1 holds the powers of tau needed by a domain of size n.
+type phase1 struct {
+	alpha1 bn254.G1Affine
+	beta1  bn254.G1Affine
+	beta2  bn254.G2Affine
+	// [τ^i]1 for i below 2n.
+	tau1 []bn254.G1Affine
+	// [L_i(τ)]1, [L_i(τ)]2, [α·L_i(τ)]1 and [β·L_i(τ)]1 for the Lagrange
+	// polynomials of the domain.
+	lagrange1      []bn254.G1Affine
+	lagrange2      []bn254.G2Affine
+	alphaLagrange1 []bn254.G1Affine
+	betaLagrange1  []bn254.G1Affine
+}
+
+// readPhase1 reads the powers of tau of a ptau file needed by a domain of size
+// n, a power of two.
+func readPhase1(r io.ReadSeeker, n int) (*phase1, error) {
+	version, sections, err := readIden3Sections(r, ptauMagic)
+	if err != nil {
+		return nil, err
+	}
+	if version != ptauVersion {
+		return nil, fmt.Errorf("unsupported ptau version: %d", version)
+	}
+	p := iden3Reader{name: "powers of tau file", r: r, sections: sections}
+
+	p.open(ptauHeaderSection, 4+fpSize+4+4)
+	baseField := p.uint32() == fpSize && p.bigInt().Cmp(fp.Modulus()) == 0
+	power := int(p.uint32())
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !baseField {
+		return nil, fmt.Errorf("powers of tau file is not for the BN254 curve")
+	}
+	// Z(X)·X^j needs the powers of tau up to 2n - 1.
+	needed := bits.TrailingZeros(uint(n)) + 1
+	if power < needed {
+		return nil, fmt.Errorf("powers of tau file of power %d is too small for a domain of size %d, which needs power %d", power, n, needed)
+	}
+	if _, ok := sections[ptauLagrangeG1Section]; !ok {
+		return nil, fmt.Errorf("powers of tau file is not prepared for phase 2, run snarkjs powersoftau prepare phase2 on it")
+	}
+
+	var result phase1
+	p.openAt(ptauTauG1Section, 0, int64(2*n)*g1Size)
+	result.tau1 = p.g1s(2 * n)
+	p.openAt(ptauAlphaTauG1Section, 0, g1Size)
+	p.g1(&result.alpha1)
+	p.openAt(ptauBetaTauG1Section, 0, g1Size)
+	p.g1(&result.beta1)
+	p.open(ptauBetaG2Section, g2Size)
+	p.g2(&result.beta2)
+	// The domains of size below n come first.
+	p.openAt(ptauLagrangeG1Section, int64(n-1)*g1Size, int64(n)*g1Size)
+	result.lagrange1 = p.g1s(n)
+	p.openAt(ptauLagrangeG2Section, int64(n-1)*g2Size, int64(n)*g2Size)
+	result.lagrange2 = p.g2s(n)
+	p.openAt(ptauAlphaLagrangeG1Section, int64(n-1)*g1Size, int64(n)*g1Size)
+	result.alphaLagrange1 = p.g1s(n)
+	p.openAt(ptauBetaLagrangeG1Section, int64(n-1)*g1Size, int64(n)*g1Size)
+	result.betaLagrange1 = p.g1s(n)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !result.beta2.IsInSubGroup() {
+		return nil, fmt.Errorf("powers of tau file holds a point outside of the G2 subgroup")
+	}
+	return &result, nil
+}
@@ -0,0 +1,160 @@
+package prover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// setupKeys are the points of a Groth16 setup, from which the keys of gnark
+// are built.
+type setupKeys struct {
+	alpha1 bn254.G1Affine
+	beta1  bn254.G1Affine
+	delta1 bn254.G1Affine
+	beta2  bn254.G2Affine
+	gamma2 bn254.G2Affine
+	delta2 bn254.G2Affine
+	// [A_i(τ)]1, [B_i(τ)]1 and [B_i(τ)]2 for every wire i, including the
+	// points at infinity.
+	a  []bn254.G1Affine
+	b1 []bn254.G1Affine
+	b2 []bn254.G2Affine
+	// [(β·A_i(τ) + α·B_i(τ) + C_i(τ))/δ]1 for the private wires, and the same
+	// divided by γ instead of δ for the public wires.
+	k  []bn254.G1Affine
+	ic []bn254.G1Affine
+	// [τ^j·Z(τ)/δ]1 for j below the size of the domain, in bit-reversed order.
+	z []bn254.G1Affine
+}
+
+// gnarkKeys builds the keys of gnark from the points. The points are expected
+// to be checked already, gnark does not check them again.
+func (keys *setupKeys) gnarkKeys() (groth16.ProvingKey, groth16.VerifyingKey, error) {
+	// gnark leaves out the points at infinity of A and B, flagging their
+	// wires instead.
+	nbWires := len(keys.a)
+	infinityA := make([]bool, nbWires)
+	infinityB := make([]bool, nbWires)
+	var pointsA, pointsB1 []bn254.G1Affine
+	var pointsB2 []bn254.G2Affine
+	for i := 0; i < nbWires; i++ {
+		infinityA[i] = keys.a[i].IsInfinity()
+		if !infinityA[i] {
+			pointsA = append(pointsA, keys.a[i])
+		}
+		infinityB[i] = keys.b1[i].IsInfinity()
+		if infinityB[i] != keys.b2[i].IsInfinity() {
+			return nil, nil, fmt.Errorf("points of wire %d in B do not match", i)
+		}
+		if !infinityB[i] {
+			pointsB1 = append(pointsB1, keys.b1[i])
+			pointsB2 = append(pointsB2, keys.b2[i])
+		}
+	}
+
+	pk := groth16.NewProvingKey(ecc.BN254)
+	err := decodeKey(pk, func(w io.Writer) error {
+		domain := fft.NewDomain(uint64(len(keys.z)))
+		if _, err := domain.WriteTo(w); err != nil {
+			return err
+		}
+		enc := bn254.NewEncoder(w, bn254.RawEncoding())
+		nbInfinityA := uint64(nbWires - len(pointsA))
+		nbInfinityB := uint64(nbWires - len(pointsB1))
+		for _, v := range []interface{}{
+			&keys.alpha1, &keys.beta1, &keys.delta1,
+			pointsA, pointsB1, keys.z, keys.k,
+			&keys.beta2, &keys.delta2, pointsB2,
+			uint64(nbWires), nbInfinityA, nbInfinityB, infinityA, infinityB,
+		} {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	err = decodeKey(vk, func(w io.Writer) error {
+		enc := bn254.NewEncoder(w)
+		for _, v := range []interface{}{
+			&keys.alpha1, &keys.beta1, &keys.beta2, &keys.gamma2, &keys.delta1, &keys.delta2, keys.ic,
+		} {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return pk, vk, nil
+}
+
+// decodeKey reads a key of gnark from the encoding written by encode, without
+// checking the subgroup of its points.
+func decodeKey(key io.ReaderFrom, encode func(w io.Writer) error) error {
+	reader, writer := io.Pipe()
+	go func() {
+		buffered := bufio.NewWriter(writer)
+		err := encode(buffered)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		writer.CloseWithError(err)
+	}()
+	var err error
+	if unsafeKey, ok := key.(interface {
+		UnsafeReadFrom(io.Reader) (int64, error)
+	}); ok {
+		_, err = unsafeKey.UnsafeReadFrom(reader)
+	} else {
+		_, err = key.ReadFrom(reader)
+	}
+	reader.CloseWithError(err)
+	return err
+}
+
+func bitReverse[T any](values []T) {
+	n := uint64(len(values))
+	shift := 64 - uint64(bits.TrailingZeros64(n))
+	for i := uint64(0); i < n; i++ {
+		j := bits.Reverse64(i) >> shift
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+}
+
+// parallelize splits [0, n) in ranges handled concurrently by work, one per
+// CPU.
+func parallelize(n int, work func(start int, end int)) {
+	nbTasks := runtime.NumCPU()
+	if nbTasks > n {
+		nbTasks = n
+	}
+	var wg sync.WaitGroup
+	for task := 0; task < nbTasks; task++ {
+		start := n * task / nbTasks
+		end := n * (task + 1) / nbTasks
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(start, end)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,230 @@
+package prover
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// A keys file is signed with ed25519 over its SHA-256 digest. The signatures
+// are detached, in a text file next to it, named after it with a .sig suffix,
+// with a line per signer:
+//
+//	<hex public key> <hex signature>
+
+// KeysSignature is the signature of a keys file by one signer.
+type KeysSignature struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// KeysTrust is the set of public keys trusted to sign keys files.
+type KeysTrust struct {
+	PublicKeys []ed25519.PublicKey
+}
+
+// SignaturePath returns the path of the signature file of a keys file.
+func SignaturePath(keysPath string) string {
+	return keysPath + ".sig"
+}
+
+// FileDigest returns the SHA-256 digest of a file, which is what keys files
+// are signed over.
+func FileDigest(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// ReadKeysSignatures reads a signature file.
+func ReadKeysSignatures(r io.Reader) ([]KeysSignature, error) {
+	var signatures []KeysSignature
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d of the signature file is not a public key and a signature", line)
+		}
+		publicKey, err := hex.DecodeString(fields[0])
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("line %d of the signature file has an invalid public key", line)
+		}
+		signature, err := hex.DecodeString(fields[1])
+		if err != nil || len(signature) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("line %d of the signature file has an invalid signature", line)
+		}
+		signatures = append(signatures, KeysSignature{PublicKey: publicKey, Signature: signature})
+	}
+	return signatures, scanner.Err()
+}
+
+// WriteKeysSignatures writes a signature file.
+func WriteKeysSignatures(w io.Writer, signatures []KeysSignature) error {
+	for _, signature := range signatures {
+		_, err := fmt.Fprintf(w, "%s %s\n", hex.EncodeToString(signature.PublicKey), hex.EncodeToString(signature.Signature))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSignatureFile(path string) ([]KeysSignature, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadKeysSignatures(file)
+}
+
+// SignKeysFile signs the keys file at path with the private key, adding the
+// signature to its signature file. The signatures of the file that no longer
+// match it are dropped, along with any previous one of the same key. It
+// returns the digest signed.
+func SignKeysFile(path string, privateKey ed25519.PrivateKey) ([]byte, error) {
+	digest, err := FileDigest(path)
+	if err != nil {
+		return nil, err
+	}
+	signaturePath := SignaturePath(path)
+	previous, err := readSignatureFile(signaturePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	var signatures []KeysSignature
+	for _, signature := range previous {
+		if !signature.PublicKey.Equal(publicKey) && ed25519.Verify(signature.PublicKey, digest, signature.Signature) {
+			signatures = append(signatures, signature)
+		}
+	}
+	signatures = append(signatures, KeysSignature{PublicKey: publicKey, Signature: ed25519.Sign(privateKey, digest)})
+
+	var content bytes.Buffer
+	if err := WriteKeysSignatures(&content, signatures); err != nil {
+		return nil, err
+	}
+	return digest, os.WriteFile(signaturePath, content.Bytes(), 0o644)
+}
+
+// Verify checks that one of the signatures of the digest is by a trusted key.
+func (trust *KeysTrust) Verify(digest []byte, signatures []KeysSignature) error {
+	for _, signature := range signatures {
+		for _, publicKey := range trust.PublicKeys {
+			if signature.PublicKey.Equal(publicKey) && ed25519.Verify(publicKey, digest, signature.Signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("keys file is not signed by a trusted key")
+}
+
+// ReadSystemFromFile reads the keys file at path like ReadSystemFromFile, once
+// it has checked that the file is signed by a trusted key. The file is not
+// decoded before, so that an untrusted file cannot reach the decoder. Without
+// trust, the signatures are not checked.
+func (trust *KeysTrust) ReadSystemFromFile(path string) (ps *ProvingSystem, err error) {
+	if trust == nil {
+		return ReadSystemFromFile(path)
+	}
+	start := time.Now()
+	signatures, err := readSignatureFile(SignaturePath(path))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the signatures of the keys file: %w", err)
+	}
+	// The file is kept open in between, so that the file decoded is the one
+	// verified even if the path is replaced.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	if err := trust.Verify(hash.Sum(nil), signatures); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readSystem(file, start)
+}
+
+// ReadTrustedKeys reads the ed25519 public keys of PEM files, such as the ones
+// written by `openssl pkey -pubout`. A file can hold several keys.
+func ReadTrustedKeys(paths []string) (*KeysTrust, error) {
+	var trust KeysTrust
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key in %s: %w", path, err)
+			}
+			publicKey, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("public key in %s is not an ed25519 key", path)
+			}
+			trust.PublicKeys = append(trust.PublicKeys, publicKey)
+		}
+	}
+	if len(trust.PublicKeys) == 0 {
+		return nil, fmt.Errorf("no public key in %s", strings.Join(paths, ", "))
+	}
+	return &trust, nil
+}
+
+// ReadSigningKey reads an ed25519 private key from a PKCS #8 PEM file, such as
+// the ones written by `openssl genpkey -algorithm ed25519`.
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not an ed25519 key", path)
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,91 @@
+package prover
+
+import (
+	"crypto/ed25519"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSignKeysFile(t *testing.T) {
+	path, _ := writeTestKeysFile(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust := &KeysTrust{PublicKeys: []ed25519.PublicKey{publicKey}}
+
+	if _, err := trust.ReadSystemFromFile(path); err == nil {
+		t.Fatal("read a keys file without signature")
+	}
+	if _, err := SignKeysFile(path, otherPrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trust.ReadSystemFromFile(path); err == nil || !strings.Contains(err.Error(), "trusted") {
+		t.Fatalf("unexpected error for an untrusted signature: %v", err)
+	}
+	if _, err := SignKeysFile(path, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := trust.ReadSystemFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.TreeDepth != 4 || ps.BatchSize != 5 {
+		t.Fatalf("unexpected proving system: depth %d, batch size %d", ps.TreeDepth, ps.BatchSize)
+	}
+	file, err := os.Open(SignaturePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	signatures, err := ReadKeysSignatures(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != 2 || !signatures[0].PublicKey.Equal(otherPublicKey) || !signatures[1].PublicKey.Equal(publicKey) {
+		t.Fatalf("unexpected signatures: %+v", signatures)
+	}
+
+	// A byte appended to the file is not read by gnark, but changes its digest.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trust.ReadSystemFromFile(path); err == nil || !strings.Contains(err.Error(), "trusted") {
+		t.Fatalf("unexpected error for a modified keys file: %v", err)
+	}
+	// Signing again drops the signatures of the previous content.
+	if _, err := SignKeysFile(path, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	signatures, err = readSignatureFile(SignaturePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != 1 {
+		t.Fatalf("unexpected signatures: %+v", signatures)
+	}
+	if _, err := trust.ReadSystemFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that does not decode is rejected for its signature, before it is
+	// decoded.
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSystemFromFile(path); err == nil || strings.Contains(err.Error(), "trusted") {
+		t.Fatalf("unexpected error for a truncated keys file: %v", err)
+	}
+	if _, err := trust.ReadSystemFromFile(path); err == nil || err.Error() != "keys file is not signed by a trusted key" {
+		t.Fatalf("unexpected error for a truncated keys file: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package prover
+
+import (
+	"math/big"
+
+	"github.com/iden3/go-iden3-crypto/poseidon"
+)
+
+// Insertions fill consecutive empty leaves starting at StartIndex, so the
+// Merkle proofs of a batch share most of their siblings. Instead of the proof
+// of every leaf, clients can send the siblings of the leaf at StartIndex in the
+// tree before the batch, leaf first. Its right siblings are roots of empty
+// subtrees, which the prover derives itself, so only the left siblings are
+// read. These form the rightmost frontier of the tree, which can be sent
+// instead of the path.
+
+// merkleProofs returns the Merkle proofs of the batch, deriving them from the
+// start path if that is what the parameters hold.
+func (p *InsertionParameters) merkleProofs() ([][]big.Int, error) {
+	if len(p.StartPath) == 0 {
+		return p.MerkleProofs, nil
+	}
+	return p.MerkleProofsFromStartPath()
+}
+
+// MerkleProofsFromStartPath derives the Merkle proof of every insertion of the
+// batch from StartPath. The path is first checked against PreRoot, failing
+// with a ValidationError for the first slot if it does not match.
+func (p *InsertionParameters) MerkleProofsFromStartPath() ([][]big.Int, error) {
+	depth := len(p.StartPath)
+	treeSize := uint64(1) << depth
+	if end := uint64(p.StartIndex) + uint64(len(p.IdComms)); end > treeSize {
+		slot := 0
+		if uint64(p.StartIndex) < treeSize {
+			slot = int(treeSize - uint64(p.StartIndex))
+		}
+		index := uint64(p.StartIndex) + uint64(slot)
+		return nil, &ValidationError{Kind: InvalidIndex, Slot: slot, Actual: new(big.Int).SetUint64(index)}
+	}
+	empty := emptyTreeHashes(depth)
+	path := reducedAll(p.StartPath)
+
+	// frontier holds the latest left node seen at every level.
+	frontier := make([]big.Int, depth)
+	start := make([]big.Int, depth)
+	for level := 0; level < depth; level++ {
+		if isRightChild(p.StartIndex, level) {
+			frontier[level].Set(&path[level])
+			start[level].Set(&path[level])
+		} else {
+			start[level].Set(&empty[level])
+		}
+	}
+	root := merkleRoot(big.NewInt(emptyLeaf), p.StartIndex, start)
+	if preRoot := reduced(&p.PreRoot); root.Cmp(preRoot) != 0 {
+		return nil, &ValidationError{Kind: InvalidMerkleProof, Slot: 0, Expected: preRoot, Actual: root}
+	}
+
+	proofs := make([][]big.Int, len(p.IdComms))
+	for i := range p.IdComms {
+		index := p.StartIndex + uint32(i)
+		proofs[i] = make([]big.Int, depth)
+		node := reduced(&p.IdComms[i])
+		for level := 0; level < depth; level++ {
+			if isRightChild(index, level) {
+				proofs[i][level].Set(&frontier[level])
+				node = hashPair(&frontier[level], node)
+			} else {
+				proofs[i][level].Set(&empty[level])
+				frontier[level].Set(node)
+				node = hashPair(node, &empty[level])
+			}
+		}
+	}
+	return proofs, nil
+}
+
+func isRightChild(index uint32, level int) bool {
+	return index>>level&1 == 1
+}
+
+func hashPair(left *big.Int, right *big.Int) *big.Int {
+	hash, _ := poseidon.Hash([]*big.Int{left, right})
+	return hash
+}
+
+// merkleRoot computes the root of a tree holding leaf at index, given its
+// siblings.
+func merkleRoot(leaf *big.Int, index uint32, siblings []big.Int) *big.Int {
+	node := leaf
+	for level := range siblings {
+		if isRightChild(index, level) {
+			node = hashPair(&siblings[level], node)
+		} else {
+			node = hashPair(node, &siblings[level])
+		}
+	}
+	return node
+}
+
+// emptyTreeHashes returns the roots of empty trees of depth 0 to depth.
+func emptyTreeHashes(depth int) []big.Int {
+	hashes := make([]big.Int, depth+1)
+	for i := 1; i <= depth; i++ {
+		hashes[i] = *hashPair(&hashes[i-1], &hashes[i-1])
+	}
+	return hashes
+}
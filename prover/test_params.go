@@ -0,0 +1,87 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// GenerateInsertionTestParams builds a valid batch of insertions of the
+// identity commitments 1, 2, ..., batchSize into an empty tree.
+func GenerateInsertionTestParams(treeDepth uint32, batchSize uint32) (*InsertionParameters, error) {
+	params := InsertionParameters{}
+	tree := NewTree(int(treeDepth))
+
+	params.StartIndex = 0
+	params.PreRoot = tree.Root()
+	params.IdComms = make([]big.Int, batchSize)
+	params.MerkleProofs = make([][]big.Int, batchSize)
+	for i := 0; i < int(batchSize); i++ {
+		params.IdComms[i] = *new(big.Int).SetUint64(uint64(i + 1))
+		params.MerkleProofs[i] = tree.Update(i, params.IdComms[i])
+	}
+	params.PostRoot = tree.Root()
+	params.ComputeInputHashInsertion()
+	return &params, nil
+}
+
+// GenerateDeletionTestParams builds a valid batch of deletions of every other
+// leaf from a tree holding the identity commitments 1, 2, ..., 2*batchSize.
+func GenerateDeletionTestParams(treeDepth uint32, batchSize uint32) (*DeletionParameters, error) {
+	if uint64(2*batchSize) > uint64(1)<<treeDepth {
+		return nil, fmt.Errorf("tree of depth %d is too small for a deletion batch of %d", treeDepth, batchSize)
+	}
+	params := DeletionParameters{}
+	tree := NewTree(int(treeDepth))
+
+	params.DeletionIndices = make([]uint32, batchSize)
+	params.IdComms = make([]big.Int, batchSize)
+	params.MerkleProofs = make([][]big.Int, batchSize)
+	for i := 0; i < int(batchSize*2); i++ {
+		tree.Update(i, *new(big.Int).SetUint64(uint64(i + 1)))
+	}
+	params.PreRoot = tree.Root()
+	for i := 0; i < int(batchSize); i++ {
+		params.DeletionIndices[i] = uint32(2 * i)
+		params.IdComms[i] = *new(big.Int).SetUint64(uint64(2*i + 1))
+		params.MerkleProofs[i] = tree.Update(2*i, *big.NewInt(0))
+	}
+	params.PostRoot = tree.Root()
+	params.ComputeInputHashDeletion()
+	return &params, nil
+}
+
+// SelfTestInsertion proves and verifies a synthetic batch of insertions,
+// checking that the proving system is a working one for the insertion circuit.
+func (ps *ProvingSystem) SelfTestInsertion() error {
+	params, err := GenerateInsertionTestParams(ps.TreeDepth, ps.BatchSize)
+	if err != nil {
+		return err
+	}
+	proof, err := ps.ProveInsertion(params)
+	if err != nil {
+		return fmt.Errorf("self-test proof failed: %w", err)
+	}
+	err = ps.VerifyInsertion(params.InputHash, proof)
+	if err != nil {
+		return fmt.Errorf("self-test verification failed: %w", err)
+	}
+	return nil
+}
+
+// SelfTestDeletion proves and verifies a synthetic batch of deletions,
+// checking that the proving system is a working one for the deletion circuit.
+func (ps *ProvingSystem) SelfTestDeletion() error {
+	params, err := GenerateDeletionTestParams(ps.TreeDepth, ps.BatchSize)
+	if err != nil {
+		return err
+	}
+	proof, err := ps.ProveDeletion(params)
+	if err != nil {
+		return fmt.Errorf("self-test proof failed: %w", err)
+	}
+	err = ps.VerifyDeletion(params.InputHash, proof)
+	if err != nil {
+		return fmt.Errorf("self-test verification failed: %w", err)
+	}
+	return nil
+}
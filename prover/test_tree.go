@@ -1,4 +1,4 @@
-package main
+package prover
 
 import (
 	"github.com/iden3/go-iden3-crypto/poseidon"
@@ -126,10 +126,5 @@ func (tree *PoseidonTree) Update(index int, value big.Int) []big.Int {
 }
 
 func NewTree(depth int) PoseidonTree {
-	initHashes := make([]big.Int, depth+1)
-	for i := 1; i <= depth; i++ {
-		val, _ := poseidon.Hash([]*big.Int{&initHashes[i-1], &initHashes[i-1]})
-		initHashes[i] = *val
-	}
-	return PoseidonTree{root: &PoseidonEmptyNode{dep: depth, emptyTreeValues: initHashes}}
+	return PoseidonTree{root: &PoseidonEmptyNode{dep: depth, emptyTreeValues: emptyTreeHashes(depth)}}
 }
@@ -0,0 +1,210 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// Kinds of ValidationError.
+const (
+	// InvalidInputHash means that InputHash is not the hash of the other
+	// parameters.
+	InvalidInputHash = "input_hash"
+	// InvalidIndex means that the index of a slot does not fit in the tree.
+	InvalidIndex = "index"
+	// InvalidMerkleProof means that the Merkle proof of a slot does not lead
+	// to the root of the tree before it.
+	InvalidMerkleProof = "merkle_proof"
+	// InvalidPostRoot means that the rounds lead to another root than
+	// PostRoot.
+	InvalidPostRoot = "post_root"
+)
+
+// ValidationError tells which part of the parameters of a batch would make the
+// circuit unsatisfiable. Slot is the position in the batch of the failing
+// round, or -1 when the failure is not about a single round. For a Merkle
+// proof, Expected is the root of the tree before the round, and Actual the
+// root the proof leads to.
+type ValidationError struct {
+	Kind     string
+	Slot     int
+	Expected *big.Int
+	Actual   *big.Int
+}
+
+func (err *ValidationError) Error() string {
+	switch err.Kind {
+	case InvalidInputHash:
+		return fmt.Sprintf("input hash is %s, expected %s", toHex(err.Actual), toHex(err.Expected))
+	case InvalidIndex:
+		return fmt.Sprintf("index %s of slot %d does not fit in the tree", err.Actual, err.Slot)
+	case InvalidMerkleProof:
+		return fmt.Sprintf("merkle proof of slot %d leads to root %s, expected %s", err.Slot, toHex(err.Actual), toHex(err.Expected))
+	case InvalidPostRoot:
+		return fmt.Sprintf("batch leads to root %s, expected post-root %s", toHex(err.Actual), toHex(err.Expected))
+	}
+	return fmt.Sprintf("invalid %s", err.Kind)
+}
+
+// reduced returns value modulo the scalar field, as it is assigned to the
+// circuit.
+func reduced(value *big.Int) *big.Int {
+	return new(big.Int).Mod(value, ecc.BN254.ScalarField())
+}
+
+func checkInputHash(given *big.Int, computed *big.Int) error {
+	expected := reduced(computed)
+	actual := reduced(given)
+	if expected.Cmp(actual) != 0 {
+		return &ValidationError{Kind: InvalidInputHash, Slot: -1, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+func checkPostRoot(given *big.Int, root *big.Int) error {
+	expected := reduced(given)
+	if expected.Cmp(root) != 0 {
+		return &ValidationError{Kind: InvalidPostRoot, Slot: -1, Expected: expected, Actual: root}
+	}
+	return nil
+}
+
+// reducedAll returns values modulo the scalar field.
+func reducedAll(values []big.Int) []big.Int {
+	result := make([]big.Int, len(values))
+	for i := range values {
+		result[i].Set(reduced(&values[i]))
+	}
+	return result
+}
+
+// Validate replays the insertion rounds of the circuit natively, returning a
+// ValidationError for the first check that fails. The shape of the parameters
+// must have been validated first.
+func (p *InsertionParameters) Validate() error {
+	merkleProofs, err := p.merkleProofs()
+	if err != nil {
+		return err
+	}
+	return p.validate(merkleProofs)
+}
+
+func (p *InsertionParameters) validate(merkleProofs [][]big.Int) error {
+	computed := InsertionParameters{StartIndex: p.StartIndex, PreRoot: p.PreRoot, PostRoot: p.PostRoot, IdComms: p.IdComms}
+	computed.ComputeInputHashInsertion()
+	if err := checkInputHash(&p.InputHash, &computed.InputHash); err != nil {
+		return err
+	}
+
+	root := reduced(&p.PreRoot)
+	for i := range p.IdComms {
+		proof := reducedAll(merkleProofs[i])
+		index := uint64(p.StartIndex) + uint64(i)
+		if index >= uint64(1)<<len(proof) {
+			return &ValidationError{Kind: InvalidIndex, Slot: i, Actual: new(big.Int).SetUint64(index)}
+		}
+		actual := merkleRoot(big.NewInt(emptyLeaf), uint32(index), proof)
+		if actual.Cmp(root) != 0 {
+			return &ValidationError{Kind: InvalidMerkleProof, Slot: i, Expected: root, Actual: actual}
+		}
+		root = merkleRoot(reduced(&p.IdComms[i]), uint32(index), proof)
+	}
+	return checkPostRoot(&p.PostRoot, root)
+}
+
+// Validate replays the deletion rounds of the circuit natively, returning a
+// ValidationError for the first check that fails. Padding slots, whose index
+// is flagged as one past the size of the tree, are skipped like in the
+// circuit. The shape of the parameters must have been validated first.
+func (p *DeletionParameters) Validate() error {
+	computed := DeletionParameters{DeletionIndices: p.DeletionIndices, PreRoot: p.PreRoot, PostRoot: p.PostRoot}
+	computed.ComputeInputHashDeletion()
+	if err := checkInputHash(&p.InputHash, &computed.InputHash); err != nil {
+		return err
+	}
+
+	root := reduced(&p.PreRoot)
+	for i, index := range p.DeletionIndices {
+		proof := reducedAll(p.MerkleProofs[i])
+		treeSize := uint64(1) << len(proof)
+		if uint64(index) >= 2*treeSize {
+			return &ValidationError{Kind: InvalidIndex, Slot: i, Actual: new(big.Int).SetUint64(uint64(index))}
+		}
+		if uint64(index) >= treeSize {
+			continue
+		}
+		actual := merkleRoot(reduced(&p.IdComms[i]), index, proof)
+		if actual.Cmp(root) != 0 {
+			return &ValidationError{Kind: InvalidMerkleProof, Slot: i, Expected: root, Actual: actual}
+		}
+		root = merkleRoot(big.NewInt(emptyLeaf), index, proof)
+	}
+	return checkPostRoot(&p.PostRoot, root)
+}
+
+// validate replays the rounds of the circuit natively on the inputs of the
+// witness, decoding them back into the parameters of its mode.
+func (w *Witness) validate() error {
+	values := w.witness.Vector().(fr.Vector)
+	inputs := make([]big.Int, len(values))
+	for i := range values {
+		values[i].BigInt(&inputs[i])
+	}
+	depth, batch := int(w.treeDepth), int(w.batchSize)
+	// index returns the input at i as an index, or the input itself if it does
+	// not fit in one.
+	index := func(i int) (uint32, *big.Int) {
+		if !inputs[i].IsUint64() || inputs[i].Uint64() > 0xffffffff {
+			return 0, &inputs[i]
+		}
+		return uint32(inputs[i].Uint64()), nil
+	}
+	// The input hash, then the start index of an insertion or the indices of
+	// a deletion, then the roots, the identity commitments and the proofs.
+	nbIndices := 1
+	if w.mode == deletionMode {
+		nbIndices = batch
+	}
+	rest := inputs[1+nbIndices:]
+	idComms := rest[2 : 2+batch]
+	merkleProofs := make([][]big.Int, batch)
+	for i := range merkleProofs {
+		merkleProofs[i] = rest[2+batch+i*depth : 2+batch+(i+1)*depth]
+	}
+
+	if w.mode == insertionMode {
+		startIndex, overflow := index(1)
+		if overflow != nil {
+			return &ValidationError{Kind: InvalidIndex, Slot: 0, Actual: overflow}
+		}
+		params := InsertionParameters{
+			InputHash:    inputs[0],
+			StartIndex:   startIndex,
+			PreRoot:      rest[0],
+			PostRoot:     rest[1],
+			IdComms:      idComms,
+			MerkleProofs: merkleProofs,
+		}
+		return params.validate(merkleProofs)
+	}
+	deletionIndices := make([]uint32, batch)
+	for i := range deletionIndices {
+		var overflow *big.Int
+		deletionIndices[i], overflow = index(1 + i)
+		if overflow != nil {
+			return &ValidationError{Kind: InvalidIndex, Slot: i, Actual: overflow}
+		}
+	}
+	params := DeletionParameters{
+		InputHash:       inputs[0],
+		DeletionIndices: deletionIndices,
+		PreRoot:         rest[0],
+		PostRoot:        rest[1],
+		IdComms:         idComms,
+		MerkleProofs:    merkleProofs,
+	}
+	return params.Validate()
+}
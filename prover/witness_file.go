@@ -0,0 +1,278 @@
+package prover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/witness"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+)
+
+// A witness can be exported in two formats. The gnark format is the binary
+// encoding of gnark witnesses, holding the inputs of the circuit only: the
+// number of public and secret inputs as big-endian uint32, then the values as
+// 32 big-endian bytes. The wtns format is the one of iden3's circom and
+// snarkjs, holding the value of every wire of the solved circuit, in the
+// layout described in iden3.go:
+//
+//	header:    "wtns", version 2 and the number of sections, 2
+//	section 1: field size 32, the modulus and the number of wires
+//	section 2: the wires
+//
+// Wires are in the order of gnark: the constant one, the public input, the
+// secret inputs and then the internal wires. This is also their order in the
+// r1cs files written by WriteR1CSIden3.
+const (
+	WitnessFormatGnark = "gnark"
+	WitnessFormatWtns  = "wtns"
+)
+
+var wtnsMagic = [4]byte{'w', 't', 'n', 's'}
+
+const (
+	wtnsVersion       = 2
+	wtnsHeaderSection = 1
+	wtnsWiresSection  = 2
+)
+
+// newWitness builds the witness of the assignment to the circuit of mode.
+func (ps *ProvingSystem) newWitness(mode string, assignment frontend.Circuit) (*Witness, error) {
+	start := time.Now()
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	observeDuration(witnessDuration, mode, ps.BatchSize, start)
+	return &Witness{mode: mode, treeDepth: ps.TreeDepth, batchSize: ps.BatchSize, witness: w}, nil
+}
+
+// nbSecret returns the number of secret inputs of the circuit of mode. The
+// input hash is the only public input.
+func (ps *ProvingSystem) nbSecret(mode string) int {
+	nbIndices := 1
+	if mode == deletionMode {
+		nbIndices = int(ps.BatchSize)
+	}
+	return nbIndices + 2 + int(ps.BatchSize) + int(ps.BatchSize*ps.TreeDepth)
+}
+
+func (ps *ProvingSystem) checkWitness(w *Witness) error {
+	if w.treeDepth != ps.TreeDepth || w.batchSize != ps.BatchSize {
+		return fmt.Errorf(
+			"witness for depth %d and batch size %d does not fit the proving system for depth %d and batch size %d",
+			w.treeDepth, w.batchSize, ps.TreeDepth, ps.BatchSize,
+		)
+	}
+	return nil
+}
+
+// WriteWitness writes the witness in format. The wtns format holds every wire
+// of the circuit, so the witness is solved first, which fails if the
+// parameters it was built from are invalid.
+func (ps *ProvingSystem) WriteWitness(writer io.Writer, w *Witness, format string) error {
+	if err := ps.checkWitness(w); err != nil {
+		return err
+	}
+	switch format {
+	case WitnessFormatGnark:
+		buffered := bufio.NewWriter(writer)
+		if _, err := w.witness.WriteTo(buffered); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	case WitnessFormatWtns:
+		wires, err := ps.solve(w)
+		if err != nil {
+			return err
+		}
+		return writeWtns(writer, wires)
+	}
+	return fmt.Errorf("unknown witness format: %s", format)
+}
+
+// solve returns the value of every wire of the circuit for the witness.
+func (ps *ProvingSystem) solve(w *Witness) (fr.Vector, error) {
+	r1cs, ok := ps.ConstraintSystem.(*cs_bn254.R1CS)
+	if !ok {
+		return nil, fmt.Errorf("unsupported constraint system: %T", ps.ConstraintSystem)
+	}
+	config, err := backend.NewProverConfig()
+	if err != nil {
+		return nil, err
+	}
+	nbConstraints := r1cs.GetNbConstraints()
+	a := make(fr.Vector, nbConstraints)
+	b := make(fr.Vector, nbConstraints)
+	c := make(fr.Vector, nbConstraints)
+	return r1cs.Solve(w.witness.Vector().(fr.Vector), a, b, c, config)
+}
+
+func writeWtns(writer io.Writer, wires fr.Vector) error {
+	w := newIden3Writer(writer, wtnsMagic, wtnsVersion, 2)
+	w.section(wtnsHeaderSection, 4+fpSize+4)
+	w.fieldHeader()
+	w.uint32(uint32(len(wires)))
+	w.section(wtnsWiresSection, fpSize*uint64(len(wires)))
+	for i := range wires {
+		w.element(&wires[i])
+	}
+	return w.flush()
+}
+
+// ReadWitnessFile reads a witness of the circuit of mode written by
+// WriteWitness, in either format, checking that it fits the proving system.
+// Only the inputs are read from a wtns file, the internal wires are solved
+// again when proving.
+func (ps *ProvingSystem) ReadWitnessFile(r io.Reader, mode string) (*Witness, error) {
+	if mode != insertionMode && mode != deletionMode {
+		return nil, fmt.Errorf("invalid mode: %s", mode)
+	}
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(len(wtnsMagic))
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	var w witness.Witness
+	if bytes.Equal(magic, wtnsMagic[:]) {
+		w, err = ps.readWtns(reader)
+	} else {
+		w, err = ps.readGnarkWitness(reader, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Witness{mode: mode, treeDepth: ps.TreeDepth, batchSize: ps.BatchSize, witness: w}, nil
+}
+
+func (ps *ProvingSystem) readGnarkWitness(r io.Reader, mode string) (witness.Witness, error) {
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("reading witness: %w", err)
+	}
+	public, err := w.Public()
+	if err != nil {
+		return nil, err
+	}
+	nbPublic := len(public.Vector().(fr.Vector))
+	nbSecret := len(w.Vector().(fr.Vector)) - nbPublic
+	if nbPublic != 1 || nbSecret != ps.nbSecret(mode) {
+		return nil, fmt.Errorf(
+			"witness with %d public and %d secret inputs does not fit the %s circuit for depth %d and batch size %d",
+			nbPublic, nbSecret, mode, ps.TreeDepth, ps.BatchSize,
+		)
+	}
+	return w, nil
+}
+
+func (ps *ProvingSystem) readWtns(r io.Reader) (witness.Witness, error) {
+	var header struct {
+		Magic      [4]byte
+		Version    uint32
+		NbSections uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if header.Version != wtnsVersion {
+		return nil, fmt.Errorf("unsupported wtns version: %d", header.Version)
+	}
+
+	nbPublic := ps.ConstraintSystem.GetNbPublicVariables()
+	nbSecret := ps.ConstraintSystem.GetNbSecretVariables()
+	nbWires := nbPublic + nbSecret + ps.ConstraintSystem.GetNbInternalVariables()
+	modulus := ecc.BN254.ScalarField()
+	var inputs fr.Vector
+	for i := uint32(0); i < header.NbSections && inputs == nil; i++ {
+		var section struct {
+			Type uint32
+			Size uint64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &section); err != nil {
+			return nil, fmt.Errorf("reading section: %w", err)
+		}
+		switch section.Type {
+		case wtnsHeaderSection:
+			var fieldHeader struct {
+				FieldSize uint32
+				Modulus   [fpSize]byte
+				NbWires   uint32
+			}
+			if section.Size != uint64(binary.Size(&fieldHeader)) {
+				return nil, fmt.Errorf("invalid wtns header size: %d", section.Size)
+			}
+			if err := binary.Read(r, binary.LittleEndian, &fieldHeader); err != nil {
+				return nil, fmt.Errorf("reading wtns header: %w", err)
+			}
+			if fieldHeader.FieldSize != fpSize || new(big.Int).SetBytes(toBytesLE(fieldHeader.Modulus[:])).Cmp(modulus) != 0 {
+				return nil, fmt.Errorf("wtns file is not over the scalar field of BN254")
+			}
+			if int(fieldHeader.NbWires) != nbWires {
+				return nil, fmt.Errorf("wtns file with %d wires does not fit the circuit with %d wires", fieldHeader.NbWires, nbWires)
+			}
+		case wtnsWiresSection:
+			if section.Size != uint64(fpSize*nbWires) {
+				return nil, fmt.Errorf("invalid wtns wires size: %d", section.Size)
+			}
+			// The inputs follow the constant one, the internal wires are not
+			// needed.
+			inputs = make(fr.Vector, nbPublic+nbSecret)
+			var buf [fpSize]byte
+			for wire := range inputs {
+				if _, err := io.ReadFull(r, buf[:]); err != nil {
+					return nil, fmt.Errorf("reading wire %d: %w", wire, err)
+				}
+				value := new(big.Int).SetBytes(toBytesLE(buf[:]))
+				if value.Cmp(modulus) >= 0 {
+					return nil, fmt.Errorf("wire %d is not a field element", wire)
+				}
+				if wire == 0 && value.Cmp(big.NewInt(1)) != 0 {
+					return nil, fmt.Errorf("wire 0 is %s, expected 1", value)
+				}
+				inputs[wire].SetBigInt(value)
+			}
+			inputs = inputs[1:]
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(section.Size)); err != nil {
+				return nil, fmt.Errorf("reading section: %w", err)
+			}
+		}
+	}
+	if inputs == nil {
+		return nil, fmt.Errorf("wtns file without wires")
+	}
+	return newWitnessFromVector(inputs, nbPublic-1, nbSecret)
+}
+
+func newWitnessFromVector(vector fr.Vector, nbPublic int, nbSecret int) (witness.Witness, error) {
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	values := make(chan any)
+	go func() {
+		defer close(values)
+		for _, value := range vector {
+			values <- value
+		}
+	}()
+	err = w.Fill(nbPublic, nbSecret, values)
+	// Drain the values in case Fill stopped early.
+	for range values {
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,368 @@
+package prover
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync/atomic"
+
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+
+	"worldcoin/gnark-mbu/logging"
+)
+
+// A zkey is the Groth16 setup snarkjs produces for the R1CS of a circuit, such
+// as the one written by WriteR1CSIden3. It follows the layout described in
+// iden3.go, with points in affine coordinates, each coordinate in 32
+// little-endian bytes in Montgomery form:
+//
+//	section 1: the protocol, 1 for Groth16
+//	section 2: the field size and modulus of the base and scalar fields, the
+//	           number of wires, of public inputs, the size of the domain, and
+//	           [α]1, [β]1, [β]2, [γ]2, [δ]1, [δ]2
+//	section 3: the points of the public wires in the verifying key
+//	section 4: the coefficients of the A and B matrices, as the matrix, the
+//	           constraint, the wire and the coefficient times R^2
+//	section 5: [A_i(τ)]1 for every wire
+//	section 6: [B_i(τ)]1 for every wire
+//	section 7: [B_i(τ)]2 for every wire
+//	section 8: the points of the private wires in the proving key
+//	section 9: [L_{2j+1}(τ)/δ]1 for j below the size n of the domain, where
+//	           L_k are the Lagrange polynomials of the domain of size 2n
+//
+// snarkjs appends a constraint to the R1CS for every public wire, including
+// the constant one, multiplying it by zero. The proving system built from a
+// zkey keeps them, so that gnark solves the same constraints as the ones of
+// the setup.
+var zkeyMagic = [4]byte{'z', 'k', 'e', 'y'}
+
+const (
+	zkeyVersion        = 1
+	zkeyGroth16        = 1
+	zkeyHeaderSection  = 1
+	zkeyGroth16Section = 2
+	zkeyICSection      = 3
+	zkeyCoeffsSection  = 4
+	zkeyASection       = 5
+	zkeyB1Section      = 6
+	zkeyB2Section      = 7
+	zkeyCSection       = 8
+	zkeyHSection       = 9
+)
+
+const (
+	g1Size = 2 * fpSize
+	g2Size = 4 * fpSize
+)
+
+// ImportInsertionZkey builds a proving system for the insertion circuit from
+// a zkey produced by snarkjs for its R1CS.
+func ImportInsertionZkey(treeDepth uint32, batchSize uint32, zkeyPath string) (*ProvingSystem, error) {
+	ccs, err := BuildR1CSInsertion(treeDepth, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return importZkey(treeDepth, batchSize, ccs, zkeyPath)
+}
+
+// ImportDeletionZkey builds a proving system for the deletion circuit from a
+// zkey produced by snarkjs for its R1CS.
+func ImportDeletionZkey(treeDepth uint32, batchSize uint32, zkeyPath string) (*ProvingSystem, error) {
+	ccs, err := BuildR1CSDeletion(treeDepth, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return importZkey(treeDepth, batchSize, ccs, zkeyPath)
+}
+
+func importZkey(treeDepth uint32, batchSize uint32, ccs constraint.ConstraintSystem, zkeyPath string) (*ProvingSystem, error) {
+	file, err := os.Open(zkeyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pk, vk, err := ReadZkey(file, ccs)
+	if err != nil {
+		return nil, err
+	}
+	return &ProvingSystem{treeDepth, batchSize, pk, vk, ccs}, nil
+}
+
+// ReadZkey reads the keys of a zkey set up for the R1CS of ccs, checking that
+// it has the same wires and that its A and B matrices are the ones of ccs. The
+// constraints snarkjs adds for the public wires are appended to ccs.
+func ReadZkey(r io.ReadSeeker, ccs constraint.ConstraintSystem) (groth16.ProvingKey, groth16.VerifyingKey, error) {
+	r1cs, ok := ccs.(*cs_bn254.R1CS)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported constraint system: %T", ccs)
+	}
+	version, sections, err := readIden3Sections(r, zkeyMagic)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != zkeyVersion {
+		return nil, nil, fmt.Errorf("unsupported zkey version: %d", version)
+	}
+	z := iden3Reader{name: "zkey", r: r, sections: sections}
+
+	z.open(zkeyHeaderSection, 4)
+	if protocol := z.uint32(); z.err == nil && protocol != zkeyGroth16 {
+		return nil, nil, fmt.Errorf("zkey is for protocol %d, only Groth16 is supported", protocol)
+	}
+
+	var keys setupKeys
+	z.open(zkeyGroth16Section, 4+fpSize+4+fpSize+3*4+3*g1Size+3*g2Size)
+	baseField := z.uint32() == fpSize && z.bigInt().Cmp(fp.Modulus()) == 0
+	scalarField := z.uint32() == fpSize && z.bigInt().Cmp(fr.Modulus()) == 0
+	if z.err == nil && !(baseField && scalarField) {
+		return nil, nil, fmt.Errorf("zkey is not for the BN254 curve")
+	}
+	zkeyWires := int(z.uint32())
+	zkeyPublic := int(z.uint32())
+	domainSize := int(z.uint32())
+	z.g1(&keys.alpha1)
+	z.g1(&keys.beta1)
+	z.g2(&keys.beta2)
+	z.g2(&keys.gamma2)
+	z.g1(&keys.delta1)
+	z.g2(&keys.delta2)
+	if z.err != nil {
+		return nil, nil, z.err
+	}
+	// G1 has a cofactor of 1, so the points on the curve are all in the
+	// subgroup, unlike the ones of G2.
+	for _, point := range []*bn254.G2Affine{&keys.beta2, &keys.gamma2, &keys.delta2} {
+		if !point.IsInSubGroup() {
+			return nil, nil, fmt.Errorf("zkey holds a point outside of the G2 subgroup")
+		}
+	}
+
+	nbPublic := r1cs.GetNbPublicVariables()
+	nbWires := nbPublic + r1cs.GetNbSecretVariables() + r1cs.GetNbInternalVariables()
+	if zkeyWires != nbWires || zkeyPublic != nbPublic-1 {
+		return nil, nil, fmt.Errorf(
+			"zkey has %d wires and %d public inputs, the circuit has %d wires and %d public inputs",
+			zkeyWires, zkeyPublic, nbWires, nbPublic-1,
+		)
+	}
+	appendSnarkjsConstraints(r1cs)
+	domain := fft.NewDomain(uint64(len(r1cs.Constraints)))
+	if uint64(domainSize) != domain.Cardinality {
+		return nil, nil, fmt.Errorf(
+			"zkey has a domain of size %d, the %d constraints of the circuit and the %d added by snarkjs need one of size %d",
+			domainSize, len(r1cs.Constraints)-nbPublic, nbPublic, domain.Cardinality,
+		)
+	}
+	if err := z.checkCoefficients(r1cs); err != nil {
+		return nil, nil, err
+	}
+
+	logging.Logger().Info().Msg("reading zkey points")
+	z.open(zkeyICSection, int64(nbPublic)*g1Size)
+	keys.ic = z.g1s(nbPublic)
+	z.open(zkeyASection, int64(nbWires)*g1Size)
+	keys.a = z.g1s(nbWires)
+	z.open(zkeyB1Section, int64(nbWires)*g1Size)
+	keys.b1 = z.g1s(nbWires)
+	z.open(zkeyB2Section, int64(nbWires)*g2Size)
+	keys.b2 = z.g2s(nbWires)
+	z.open(zkeyCSection, int64(nbWires-nbPublic)*g1Size)
+	keys.k = z.g1s(nbWires - nbPublic)
+	z.open(zkeyHSection, int64(domainSize)*g1Size)
+	h := z.g1s(domainSize)
+	if z.err != nil {
+		return nil, nil, z.err
+	}
+	// Only the points in G2 need a subgroup check. The points of B in G2
+	// are checked in parallel, there is one per wire.
+	logging.Logger().Info().Int("points", len(keys.b2)).Msg("checking zkey G2 points")
+	var outsideG2 atomic.Bool
+	parallelize(len(keys.b2), func(start int, end int) {
+		for i := start; i < end && !outsideG2.Load(); i++ {
+			if !keys.b2[i].IsInSubGroup() {
+				outsideG2.Store(true)
+			}
+		}
+	})
+	if outsideG2.Load() {
+		return nil, nil, fmt.Errorf("zkey holds a point outside of the G2 subgroup")
+	}
+
+	logging.Logger().Info().Int("domainSize", domainSize).Msg("converting zkey H points")
+	keys.z = zPoints(h)
+	return keys.gnarkKeys()
+}
+
+// appendSnarkjsConstraints appends the constraints snarkjs adds for every
+// public wire, multiplying it by zero.
+func appendSnarkjsConstraints(r1cs *cs_bn254.R1CS) {
+	zero := constraint.LinearExpression{{CID: constraint.CoeffIdZero, VID: 0}}
+	for wire := 0; wire < r1cs.GetNbPublicVariables(); wire++ {
+		r1cs.AddConstraint(constraint.R1C{
+			L: constraint.LinearExpression{{CID: constraint.CoeffIdOne, VID: uint32(wire)}},
+			R: zero,
+			O: zero,
+		})
+	}
+}
+
+// checkCoefficients checks that the A and B matrices of the zkey are the L and
+// R ones of the R1CS. Rather than sorting the coefficients of both, every
+// constraint is compared through the sum of its terms weighted by random
+// values given to the wires.
+func (z *iden3Reader) checkCoefficients(r1cs *cs_bn254.R1CS) error {
+	section, ok := z.sections[zkeyCoeffsSection]
+	if !ok {
+		return fmt.Errorf("zkey has no section %d", zkeyCoeffsSection)
+	}
+	if section.size < 4 {
+		return fmt.Errorf("zkey section %d has %d bytes", zkeyCoeffsSection, section.size)
+	}
+	z.open(zkeyCoeffsSection, section.size)
+	nbCoefficients := int64(z.uint32())
+	if z.err == nil && section.size != 4+nbCoefficients*(3*4+fpSize) {
+		return fmt.Errorf("zkey section %d has %d bytes for %d coefficients", zkeyCoeffsSection, section.size, nbCoefficients)
+	}
+
+	nbWires := r1cs.GetNbPublicVariables() + r1cs.GetNbSecretVariables() + r1cs.GetNbInternalVariables()
+	weights := make([]fr.Element, nbWires)
+	for i := range weights {
+		if _, err := weights[i].SetRandom(); err != nil {
+			return err
+		}
+	}
+	nbConstraints := len(r1cs.Constraints)
+	expected := [2][]fr.Element{make([]fr.Element, nbConstraints), make([]fr.Element, nbConstraints)}
+	var term fr.Element
+	for i, r1c := range r1cs.Constraints {
+		for matrix, expression := range []constraint.LinearExpression{r1c.L, r1c.R} {
+			for _, t := range expression {
+				wire := t.VID
+				if t.IsConstant() {
+					wire = 0
+				}
+				term.Mul(&r1cs.Coefficients[t.CID], &weights[wire])
+				expected[matrix][i].Add(&expected[matrix][i], &term)
+			}
+		}
+	}
+
+	// The coefficients are multiplied by R^2, R being the Montgomery constant
+	// 2^256 of the scalar field.
+	var unscale fr.Element
+	unscale.SetBigInt(new(big.Int).Lsh(big.NewInt(1), 8*fpSize))
+	unscale.Square(&unscale).Inverse(&unscale)
+	actual := [2][]fr.Element{make([]fr.Element, nbConstraints), make([]fr.Element, nbConstraints)}
+	for i := int64(0); i < nbCoefficients && z.err == nil; i++ {
+		matrix := z.uint32()
+		index := z.uint32()
+		wire := z.uint32()
+		if z.err == nil && (matrix > 1 || int(index) >= nbConstraints || int(wire) >= nbWires) {
+			return fmt.Errorf(
+				"zkey has a coefficient for wire %d of constraint %d, the circuit has %d wires and %d constraints with the ones added by snarkjs",
+				wire, index, nbWires, nbConstraints,
+			)
+		}
+		term.SetBigInt(z.bigInt())
+		term.Mul(&term, &unscale).Mul(&term, &weights[wire])
+		actual[matrix][index].Add(&actual[matrix][index], &term)
+	}
+	if z.err != nil {
+		return z.err
+	}
+
+	nbCircuitConstraints := nbConstraints - r1cs.GetNbPublicVariables()
+	for i := 0; i < nbConstraints; i++ {
+		for matrix, name := range []string{"A", "B"} {
+			if expected[matrix][i].Equal(&actual[matrix][i]) {
+				continue
+			}
+			if i >= nbCircuitConstraints {
+				return fmt.Errorf("%s of constraint %d of the zkey is not the one snarkjs adds for public wire %d", name, i, i-nbCircuitConstraints)
+			}
+			return fmt.Errorf(
+				"%s of constraint %d of the zkey does not match the circuit: the zkey was set up for another r1cs, or one with another order of wires",
+				name, i,
+			)
+		}
+	}
+	return nil
+}
+
+// zPoints converts the H points of a zkey to the Z points of a gnark proving
+// key, [τ^k·Z(τ)/δ]1 in bit-reversed order for k below the size n of the
+// domain, with Z(X) = X^n - 1.
+//
+// With ω the generator of the domain and g the one of the domain of size 2n,
+// the points of the zkey are [L_{2j+1}(τ)/δ]1, and g^{2j+1} = g·ω^j are the
+// roots of X^n + 1. A polynomial P of degree below 2n that is zero on the
+// domain is the sum of P(g·ω^j)·L_{2j+1}, so for P = X^k·Z, which is -2·(g·ω^j)^k
+// at g·ω^j:
+//
+//	[τ^k·Z(τ)/δ]1 = -2·g^k·Σ_j ω^{jk}·[L_{2j+1}(τ)/δ]1
+//
+// The sums are the discrete Fourier transform of the points of the zkey, which
+// takes n/2·log(n) scalar multiplications.
+func zPoints(h []bn254.G1Affine) []bn254.G1Affine {
+	n := len(h)
+	domain := fft.NewDomain(uint64(n))
+	points := make([]bn254.G1Jac, n)
+	for i := range h {
+		points[i].FromAffine(&h[i])
+	}
+	fourierTransformG1(points, &domain.Generator)
+
+	g := fft.NewDomain(uint64(2 * n)).Generator
+	var minusTwo fr.Element
+	minusTwo.SetInt64(-2)
+	parallelize(n, func(start int, end int) {
+		var scalar fr.Element
+		var k big.Int
+		scalar.Exp(g, big.NewInt(int64(start))).Mul(&scalar, &minusTwo)
+		for i := start; i < end; i++ {
+			points[i].ScalarMultiplication(&points[i], scalar.BigInt(&k))
+			scalar.Mul(&scalar, &g)
+		}
+	})
+	result := bn254.BatchJacobianToAffineG1(points)
+	bitReverse(result)
+	return result
+}
+
+// fourierTransformG1 replaces the points with Σ_j ω^{jk}·points[j] for every
+// k, with the iterative radix-2 algorithm.
+func fourierTransformG1(points []bn254.G1Jac, omega *fr.Element) {
+	n := len(points)
+	bitReverse(points)
+	twiddles := make([]big.Int, n/2)
+	var power fr.Element
+	power.SetOne()
+	for i := range twiddles {
+		power.BigInt(&twiddles[i])
+		power.Mul(&power, omega)
+	}
+	for size := 2; size <= n; size *= 2 {
+		half := size / 2
+		stride := n / size
+		parallelize(n/2, func(start int, end int) {
+			var t bn254.G1Jac
+			for butterfly := start; butterfly < end; butterfly++ {
+				j := butterfly % half
+				k := butterfly/half*size + j
+				t.Set(&points[k+half])
+				if j != 0 {
+					t.ScalarMultiplication(&t, &twiddles[j*stride])
+				}
+				points[k+half].Set(&points[k]).SubAssign(&t)
+				points[k].AddAssign(&t)
+			}
+		})
+	}
+}
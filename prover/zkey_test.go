@@ -0,0 +1,285 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bn254 "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+func compileIden3Circuit(t *testing.T, nbInputs int) *cs_bn254.R1CS {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &TestIden3Circuit{X: make([]frontend.Variable, nbInputs)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs.(*cs_bn254.R1CS)
+}
+
+// lagrange returns L_k(τ) for every k below the size of the domain of
+// generator omega.
+func lagrange(tau *fr.Element, omega *fr.Element, size int) []fr.Element {
+	var tauN, denominator, one fr.Element
+	one.SetOne()
+	tauN.Exp(*tau, big.NewInt(int64(size))).Sub(&tauN, &one)
+	values := make([]fr.Element, size)
+	root := one
+	for k := range values {
+		// L_k(τ) = ω^k·(τ^n - 1) / (n·(τ - ω^k))
+		denominator.Sub(tau, &root).Mul(&denominator, new(fr.Element).SetInt64(int64(size)))
+		values[k].Div(&tauN, &denominator).Mul(&values[k], &root)
+		root.Mul(&root, omega)
+	}
+	return values
+}
+
+// writeTestG1 writes [scalar]1 the way iden3 files hold points.
+func writeTestG1(data *bytes.Buffer, scalar fr.Element) {
+	_, _, g1, _ := bn254.Generators()
+	var point bn254.G1Affine
+	point.ScalarMultiplication(&g1, scalar.BigInt(new(big.Int)))
+	writeTestFp(data, &point.X)
+	writeTestFp(data, &point.Y)
+}
+
+// writeTestG2 writes [scalar]2 the way iden3 files hold points.
+func writeTestG2(data *bytes.Buffer, scalar fr.Element) {
+	_, _, _, g2 := bn254.Generators()
+	var point bn254.G2Affine
+	point.ScalarMultiplication(&g2, scalar.BigInt(new(big.Int)))
+	writeTestFp(data, &point.X.A0)
+	writeTestFp(data, &point.X.A1)
+	writeTestFp(data, &point.Y.A0)
+	writeTestFp(data, &point.Y.A1)
+}
+
+func writeTestFp(data *bytes.Buffer, e *fp.Element) {
+	for _, limb := range e {
+		data.Write(binary.LittleEndian.AppendUint64(nil, limb))
+	}
+}
+
+// writeTestSection writes a section of an iden3 file, with the content data
+// holds once write is done.
+func writeTestSection(data *bytes.Buffer, sectionType uint32, write func()) {
+	var content bytes.Buffer
+	content, *data = *data, content
+	write()
+	content, *data = *data, content
+	data.Write(binary.LittleEndian.AppendUint32(nil, sectionType))
+	data.Write(binary.LittleEndian.AppendUint64(nil, uint64(content.Len())))
+	data.Write(content.Bytes())
+}
+
+// writeTestZkey writes the zkey snarkjs would set up for the R1CS, which must
+// already hold the constraints snarkjs adds, with toxic waste of known value.
+func writeTestZkey(t *testing.T, r1cs *cs_bn254.R1CS) []byte {
+	var tau, alpha, beta, gamma, delta fr.Element
+	tau.SetUint64(1234567)
+	alpha.SetUint64(11)
+	beta.SetUint64(13)
+	gamma.SetUint64(17)
+	delta.SetUint64(19)
+
+	nbPublic := r1cs.GetNbPublicVariables()
+	nbWires := nbPublic + r1cs.GetNbSecretVariables() + r1cs.GetNbInternalVariables()
+	domain := fft.NewDomain(uint64(len(r1cs.Constraints)))
+	n := int(domain.Cardinality)
+	lagrangeTau := lagrange(&tau, &domain.Generator, n)
+
+	var montgomery fr.Element
+	montgomery.SetBigInt(new(big.Int).Lsh(big.NewInt(1), 8*fpSize))
+	montgomery.Square(&montgomery)
+	var coefficients bytes.Buffer
+	nbCoefficients := uint32(0)
+	polynomials := [3][]fr.Element{make([]fr.Element, nbWires), make([]fr.Element, nbWires), make([]fr.Element, nbWires)}
+	for i, r1c := range r1cs.Constraints {
+		for matrix, expression := range []constraint.LinearExpression{r1c.L, r1c.R, r1c.O} {
+			for _, term := range iden3Terms(r1cs, expression, nil) {
+				var value fr.Element
+				value.Mul(&term.coeff, &lagrangeTau[i])
+				polynomials[matrix][term.wire].Add(&polynomials[matrix][term.wire], &value)
+				if matrix < 2 {
+					value.Mul(&term.coeff, &montgomery)
+					bytes := value.Bytes()
+					coefficients.Write(binary.LittleEndian.AppendUint32(nil, uint32(matrix)))
+					coefficients.Write(binary.LittleEndian.AppendUint32(nil, uint32(i)))
+					coefficients.Write(binary.LittleEndian.AppendUint32(nil, term.wire))
+					coefficients.Write(toBytesLE(bytes[:]))
+					nbCoefficients++
+				}
+			}
+		}
+	}
+
+	var data bytes.Buffer
+	writeG1 := func(scalar fr.Element) { writeTestG1(&data, scalar) }
+	writeG2 := func(scalar fr.Element) { writeTestG2(&data, scalar) }
+	section := func(sectionType uint32, write func()) { writeTestSection(&data, sectionType, write) }
+	// (β·A_i(τ) + α·B_i(τ) + C_i(τ)) / divisor
+	combination := func(wire int, divisor *fr.Element) fr.Element {
+		var value, term fr.Element
+		value.Mul(&beta, &polynomials[0][wire])
+		term.Mul(&alpha, &polynomials[1][wire])
+		value.Add(&value, &term).Add(&value, &polynomials[2][wire])
+		return *value.Div(&value, divisor)
+	}
+
+	data.Write(zkeyMagic[:])
+	data.Write(binary.LittleEndian.AppendUint32(nil, zkeyVersion))
+	data.Write(binary.LittleEndian.AppendUint32(nil, 9))
+	section(zkeyHeaderSection, func() {
+		data.Write(binary.LittleEndian.AppendUint32(nil, zkeyGroth16))
+	})
+	section(zkeyGroth16Section, func() {
+		for _, modulus := range []*big.Int{fp.Modulus(), fr.Modulus()} {
+			data.Write(binary.LittleEndian.AppendUint32(nil, fpSize))
+			data.Write(toBytesLE(modulus.FillBytes(make([]byte, fpSize))))
+		}
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(nbWires)))
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(nbPublic-1)))
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(n)))
+		writeG1(alpha)
+		writeG1(beta)
+		writeG2(beta)
+		writeG2(gamma)
+		writeG1(delta)
+		writeG2(delta)
+	})
+	section(zkeyICSection, func() {
+		for wire := 0; wire < nbPublic; wire++ {
+			writeG1(combination(wire, &gamma))
+		}
+	})
+	section(zkeyCoeffsSection, func() {
+		data.Write(binary.LittleEndian.AppendUint32(nil, nbCoefficients))
+		data.Write(coefficients.Bytes())
+	})
+	section(zkeyASection, func() {
+		for wire := 0; wire < nbWires; wire++ {
+			writeG1(polynomials[0][wire])
+		}
+	})
+	section(zkeyB1Section, func() {
+		for wire := 0; wire < nbWires; wire++ {
+			writeG1(polynomials[1][wire])
+		}
+	})
+	section(zkeyB2Section, func() {
+		for wire := 0; wire < nbWires; wire++ {
+			writeG2(polynomials[1][wire])
+		}
+	})
+	section(zkeyCSection, func() {
+		for wire := nbPublic; wire < nbWires; wire++ {
+			writeG1(combination(wire, &delta))
+		}
+	})
+	section(zkeyHSection, func() {
+		lagrange2N := lagrange(&tau, &fft.NewDomain(uint64(2*n)).Generator, 2*n)
+		for j := 0; j < n; j++ {
+			var value fr.Element
+			writeG1(*value.Div(&lagrange2N[2*j+1], &delta))
+		}
+	})
+	return data.Bytes()
+}
+
+// proveTestIden3 checks that a valid assignment of TestIden3Circuit is proven
+// and verified with the keys, and that an invalid one is not proven.
+func proveTestIden3(t *testing.T, ccs constraint.ConstraintSystem, pk groth16.ProvingKey, vk groth16.VerifyingKey) {
+	assignment := TestIden3Circuit{Y: 170, X: []frontend.Variable{5, 2, 3}}
+	w, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ccs, pk, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := w.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groth16.Verify(proof, vk, public); err != nil {
+		t.Fatal(err)
+	}
+
+	assignment.Y = 171
+	w, err = frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := groth16.Prove(ccs, pk, w); err == nil {
+		t.Fatal("proved an invalid assignment")
+	}
+}
+
+// A proving system imported from a zkey must prove the circuit it was set up
+// for.
+func TestReadZkey(t *testing.T) {
+	setup := compileIden3Circuit(t, 3)
+	appendSnarkjsConstraints(setup)
+	zkey := writeTestZkey(t, setup)
+
+	ccs := compileIden3Circuit(t, 3)
+	pk, vk, err := ReadZkey(bytes.NewReader(zkey), ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proveTestIden3(t, ccs, pk, vk)
+}
+
+func TestReadZkeyMismatch(t *testing.T) {
+	setup := compileIden3Circuit(t, 3)
+	appendSnarkjsConstraints(setup)
+	zkey := writeTestZkey(t, setup)
+
+	_, _, err := ReadZkey(bytes.NewReader(zkey), compileIden3Circuit(t, 4))
+	if err == nil || !strings.Contains(err.Error(), "wires") {
+		t.Fatalf("unexpected error for another number of wires: %v", err)
+	}
+
+	// The last coefficient of the circuit is changed, leaving its wires alone.
+	setup = compileIden3Circuit(t, 3)
+	setup.Coefficients[len(setup.Coefficients)-1].SetUint64(7)
+	appendSnarkjsConstraints(setup)
+	zkey = writeTestZkey(t, setup)
+	_, _, err = ReadZkey(bytes.NewReader(zkey), compileIden3Circuit(t, 3))
+	if err == nil || !strings.Contains(err.Error(), "another r1cs") {
+		t.Fatalf("unexpected error for another circuit: %v", err)
+	}
+
+	_, _, err = ReadZkey(bytes.NewReader(zkey[:100]), compileIden3Circuit(t, 3))
+	if err == nil {
+		t.Fatal("read a truncated zkey")
+	}
+
+	// The last point of B in G2 is replaced by one on the curve, outside of
+	// the subgroup.
+	setup = compileIden3Circuit(t, 3)
+	appendSnarkjsConstraints(setup)
+	zkey = writeTestZkey(t, setup)
+	b2 := iden3Sections(t, zkey, zkeyMagic)[zkeyB2Section]
+	outside := outsideG2(t)
+	var point bytes.Buffer
+	for _, coordinate := range []*fp.Element{&outside.X.A0, &outside.X.A1, &outside.Y.A0, &outside.Y.A1} {
+		writeTestFp(&point, coordinate)
+	}
+	copy(b2[len(b2)-point.Len():], point.Bytes())
+	_, _, err = ReadZkey(bytes.NewReader(zkey), compileIden3Circuit(t, 3))
+	if err == nil || !strings.Contains(err.Error(), "G2 subgroup") {
+		t.Fatalf("unexpected error for a point outside of G2: %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/server/pb"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcStatus converts the error to a gRPC status carrying it as a detail, so
+// that clients see the same error codes as over HTTP.
+func (error *Error) grpcStatus() error {
+	requestErrors.WithLabelValues(error.Code).Inc()
+	code := codes.Unknown
+	switch error.StatusCode {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	grpcStatus, err := status.New(code, error.Message).WithDetails(error.toProto())
+	if err != nil {
+		return status.Error(code, error.Message)
+	}
+	return grpcStatus.Err()
+}
+
+func (error *Error) toProto() *pb.Error {
+	errorProto := &pb.Error{Code: error.Code, Message: error.Message}
+	if details := error.Details; details != nil {
+		errorProto.Details = &pb.ErrorDetails{Kind: details.Kind, Path: details.Path, Expected: details.Expected, Actual: details.Actual}
+		if details.Slot != nil {
+			slot := uint32(*details.Slot)
+			errorProto.Details.Slot = &slot
+		}
+	}
+	return errorProto
+}
+
+// grpcService implements the gRPC API on top of the same proving pool and jobs
+// as the HTTP API.
+type grpcService struct {
+	pb.UnimplementedProverServer
+	mode           string
+	system         *SystemHolder
+	pool           *proverPool
+	jobs           *jobsHandler
+	maxProvingTime time.Duration
+}
+
+func (service *grpcService) Prove(ctx context.Context, request *pb.ProveRequest) (*pb.ProveResponse, error) {
+	logging.Logger().Info().Msg("received grpc prove request")
+	if service.system.Get() == nil {
+		return nil, notReadyError().grpcStatus()
+	}
+	proofRequest, requestErr := proofRequestFromProto(service.mode, request.GetParameters())
+	if requestErr != nil {
+		return nil, requestErr.grpcStatus()
+	}
+	// The deadline of the call applies to the proof, capped at the maximum
+	// proving time.
+	if service.maxProvingTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, service.maxProvingTime)
+		defer cancel()
+	}
+	proof, proveErr := proveOnPool(ctx, service.pool, service.system, proofRequest)
+	if proveErr != nil {
+		return nil, proveErr.grpcStatus()
+	}
+	proofProto, err := proofToProto(proof)
+	if err != nil {
+		return nil, unexpectedError(err).grpcStatus()
+	}
+	return &pb.ProveResponse{Proof: proofProto}, nil
+}
+
+func (service *grpcService) SubmitJob(ctx context.Context, request *pb.ProveRequest) (*pb.Job, error) {
+	logging.Logger().Info().Msg("received grpc prove job")
+	if service.system.Get() == nil {
+		return nil, notReadyError().grpcStatus()
+	}
+	proofRequest, requestErr := proofRequestFromProto(service.mode, request.GetParameters())
+	if requestErr != nil {
+		return nil, requestErr.grpcStatus()
+	}
+	// Jobs outlive the call submitting them, so their context is not derived
+	// from the one of the call.
+	var jobCtx context.Context
+	var cancel context.CancelFunc
+	if service.maxProvingTime > 0 {
+		jobCtx, cancel = context.WithTimeout(context.Background(), service.maxProvingTime)
+	} else {
+		jobCtx, cancel = context.WithCancel(context.Background())
+	}
+	job, submitErr := service.jobs.enqueue(jobCtx, cancel, proofRequest)
+	if submitErr != nil {
+		return nil, submitErr.grpcStatus()
+	}
+	return jobToProto(job)
+}
+
+func (service *grpcService) WatchJob(request *pb.WatchJobRequest, stream pb.Prover_WatchJobServer) error {
+	err := service.jobs.watch(stream.Context(), request.GetId(), func(job *ProofJob) error {
+		jobProto, err := jobToProto(job)
+		if err != nil {
+			return err
+		}
+		return stream.Send(jobProto)
+	})
+	if errors.Is(err, ErrJobNotFound) {
+		return jobNotFoundError(request.GetId()).grpcStatus()
+	}
+	return err
+}
+
+func (service *grpcService) Verify(ctx context.Context, request *pb.VerifyRequest) (*pb.VerifyResponse, error) {
+	logging.Logger().Info().Msg("received grpc verify request")
+	provingSystem := service.system.Get()
+	if provingSystem == nil {
+		return nil, notReadyError().grpcStatus()
+	}
+	if request.GetProof() == nil {
+		return nil, malformedBodyError(fmt.Errorf("missing proof")).grpcStatus()
+	}
+	proof, requestErr := proofFromProto(request.GetProof())
+	if requestErr != nil {
+		return nil, requestErr.grpcStatus()
+	}
+
+	var given *big.Int
+	switch publicInput := request.GetPublicInput().(type) {
+	case *pb.VerifyRequest_InputHash:
+		inputHash, requestErr := uint256FromProto(publicInput.InputHash, "input_hash")
+		if requestErr != nil {
+			return nil, requestErr.grpcStatus()
+		}
+		given = &inputHash
+	case *pb.VerifyRequest_Parameters:
+		params, paramsErr := proofRequestFromProto(service.mode, publicInput.Parameters)
+		if paramsErr != nil {
+			return nil, paramsErr.grpcStatus()
+		}
+		computed := params.computeInputHash()
+		given = &computed
+	}
+	inputHash, requestErr := publicInput(given, proof)
+	if requestErr != nil {
+		return nil, requestErr.grpcStatus()
+	}
+	if inputHash == nil {
+		return nil, malformedBodyError(fmt.Errorf("missing input_hash or parameters")).grpcStatus()
+	}
+
+	err := verifyProof(service.mode, provingSystem, *inputHash, proof)
+	response := &pb.VerifyResponse{Valid: err == nil, InputHash: fieldToProto(inputHash)}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	logging.Logger().Info().Bool("valid", response.Valid).Msg("verification complete")
+	return response, nil
+}
+
+func (service *grpcService) GetInfo(ctx context.Context, request *pb.GetInfoRequest) (*pb.GetInfoResponse, error) {
+	provingSystem := service.system.Get()
+	if provingSystem == nil {
+		return nil, notReadyError().grpcStatus()
+	}
+	info, err := newInfo(service.mode, provingSystem)
+	if err != nil {
+		return nil, unexpectedError(err).grpcStatus()
+	}
+	vkHash, err := hex.DecodeString(info.VerifyingKeyHash)
+	if err != nil {
+		return nil, unexpectedError(err).grpcStatus()
+	}
+	return &pb.GetInfoResponse{
+		Mode:             info.Mode,
+		TreeDepth:        info.TreeDepth,
+		BatchSize:        info.BatchSize,
+		Constraints:      uint64(info.Constraints),
+		Backend:          info.Backend,
+		Curve:            info.Curve,
+		VerifyingKeyHash: vkHash,
+	}, nil
+}
+
+func spawnGrpcServerJob(server *grpc.Server, address string, label string) RunningJob {
+	start := func() error {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		return server.Serve(listener)
+	}
+	shutdown := func() error {
+		logging.Logger().Info().Msgf("shutting down %s", label)
+		server.GracefulStop()
+		logging.Logger().Info().Msgf("%s shut down", label)
+		return nil
+	}
+	return SpawnJob(label, start, shutdown)
+}
+
+const fieldElementSize = 32
+
+// uint256FromProto decodes a number of up to 256 bits, such as an input hash.
+func uint256FromProto(bytes []byte, path string) (big.Int, *Error) {
+	var element big.Int
+	element.SetBytes(bytes)
+	if len(bytes) > fieldElementSize {
+		return element, decodeError(&prover.FieldElementError{Kind: prover.NumberOutOfRange, Path: path, Value: "0x" + element.Text(16)}, "")
+	}
+	return element, nil
+}
+
+// fieldFromProto decodes an element of the scalar field.
+func fieldFromProto(bytes []byte, path string) (big.Int, *Error) {
+	element, err := uint256FromProto(bytes, path)
+	if err == nil && element.Cmp(ecc.BN254.ScalarField()) >= 0 {
+		err = decodeError(&prover.FieldElementError{Kind: prover.NumberOutOfRange, Path: path, Value: "0x" + element.Text(16)}, "")
+	}
+	return element, err
+}
+
+func fieldsFromProto(values [][]byte, path string) ([]big.Int, *Error) {
+	elements := make([]big.Int, len(values))
+	for i, value := range values {
+		var err *Error
+		elements[i], err = fieldFromProto(value, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+func merkleProofsFromProto(proofs []*pb.MerkleProof) ([][]big.Int, *Error) {
+	elements := make([][]big.Int, len(proofs))
+	for i, proof := range proofs {
+		var err *Error
+		elements[i], err = fieldsFromProto(proof.GetSiblings(), fmt.Sprintf("merkle_proofs[%d].siblings", i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+func fieldToProto(element *big.Int) []byte {
+	return element.FillBytes(make([]byte, fieldElementSize))
+}
+
+// proofRequestFromProto converts the parameters of a gRPC call, which must
+// match the mode of the server.
+func proofRequestFromProto(mode string, parameters *pb.Parameters) (*proofRequest, *Error) {
+	var request proofRequest
+	var err *Error
+	if insertion := parameters.GetInsertion(); insertion != nil && mode == InsertionMode {
+		params := &prover.InsertionParameters{StartIndex: insertion.GetStartIndex()}
+		if params.InputHash, err = uint256FromProto(insertion.GetInputHash(), "input_hash"); err != nil {
+			return nil, err
+		}
+		if params.PreRoot, err = fieldFromProto(insertion.GetPreRoot(), "pre_root"); err != nil {
+			return nil, err
+		}
+		if params.PostRoot, err = fieldFromProto(insertion.GetPostRoot(), "post_root"); err != nil {
+			return nil, err
+		}
+		if params.IdComms, err = fieldsFromProto(insertion.GetIdentityCommitments(), "identity_commitments"); err != nil {
+			return nil, err
+		}
+		if params.MerkleProofs, err = merkleProofsFromProto(insertion.GetMerkleProofs()); err != nil {
+			return nil, err
+		}
+		if params.StartPath, err = fieldsFromProto(insertion.GetStartPath(), "start_path"); err != nil {
+			return nil, err
+		}
+		if len(insertion.GetInputHash()) == 0 {
+			params.ComputeInputHashInsertion()
+		}
+		request.insertion = params
+	} else if deletion := parameters.GetDeletion(); deletion != nil && mode == DeletionMode {
+		params := &prover.DeletionParameters{DeletionIndices: deletion.GetDeletionIndices()}
+		if params.InputHash, err = uint256FromProto(deletion.GetInputHash(), "input_hash"); err != nil {
+			return nil, err
+		}
+		if params.PreRoot, err = fieldFromProto(deletion.GetPreRoot(), "pre_root"); err != nil {
+			return nil, err
+		}
+		if params.PostRoot, err = fieldFromProto(deletion.GetPostRoot(), "post_root"); err != nil {
+			return nil, err
+		}
+		if params.IdComms, err = fieldsFromProto(deletion.GetIdentityCommitments(), "identity_commitments"); err != nil {
+			return nil, err
+		}
+		if params.MerkleProofs, err = merkleProofsFromProto(deletion.GetMerkleProofs()); err != nil {
+			return nil, err
+		}
+		if len(deletion.GetInputHash()) == 0 {
+			params.ComputeInputHashDeletion()
+		}
+		request.deletion = params
+	} else {
+		return nil, malformedBodyError(fmt.Errorf("expected %s parameters", mode))
+	}
+	return &request, nil
+}
+
+func proofToProto(proof *prover.Proof) (*pb.Proof, error) {
+	elements, err := proof.Elements()
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([][]byte, len(elements))
+	for i := range elements {
+		encoded[i] = fieldToProto(&elements[i])
+	}
+	proofProto := &pb.Proof{Ar: encoded[0:2], Bs: encoded[2:6], Krs: encoded[6:8]}
+	if proof.InputHash != nil {
+		proofProto.InputHash = fieldToProto(proof.InputHash)
+	}
+	return proofProto, nil
+}
+
+func proofFromProto(proof *pb.Proof) (*prover.Proof, *Error) {
+	if len(proof.GetAr()) != 2 || len(proof.GetBs()) != 4 || len(proof.GetKrs()) != 2 {
+		return nil, malformedBodyError(fmt.Errorf("proof must have 2 ar, 4 bs and 2 krs elements"))
+	}
+	var elements [8]big.Int
+	encoded := append(append(append([][]byte{}, proof.GetAr()...), proof.GetBs()...), proof.GetKrs()...)
+	for i, value := range encoded {
+		var err *Error
+		elements[i], err = uint256FromProto(value, "proof")
+		if err != nil {
+			return nil, err
+		}
+	}
+	decoded, err := prover.ProofFromElements(elements)
+	if err != nil {
+		return nil, malformedBodyError(err)
+	}
+	if len(proof.GetInputHash()) > 0 {
+		inputHash, requestErr := uint256FromProto(proof.GetInputHash(), "proof.input_hash")
+		if requestErr != nil {
+			return nil, requestErr
+		}
+		decoded.InputHash = &inputHash
+	}
+	return decoded, nil
+}
+
+var jobStatusToProto = map[JobStatus]pb.JobStatus{
+	JobQueued:    pb.JobStatus_JOB_STATUS_QUEUED,
+	JobRunning:   pb.JobStatus_JOB_STATUS_RUNNING,
+	JobCompleted: pb.JobStatus_JOB_STATUS_COMPLETED,
+	JobFailed:    pb.JobStatus_JOB_STATUS_FAILED,
+	JobCancelled: pb.JobStatus_JOB_STATUS_CANCELLED,
+}
+
+func jobToProto(job *ProofJob) (*pb.Job, error) {
+	jobProto := &pb.Job{Id: job.ID, Status: jobStatusToProto[job.Status]}
+	if job.Proof != nil {
+		proof, err := proofToProto(job.Proof)
+		if err != nil {
+			return nil, err
+		}
+		jobProto.Proof = proof
+	}
+	if job.Error != nil {
+		jobProto.Error = job.Error.toProto()
+	}
+	return jobProto, nil
+}
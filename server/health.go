@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+
+	"worldcoin/gnark-mbu/prover"
+)
+
+// SystemHolder gives the handlers access to the proving system, which is not
+// available until it has been loaded.
+type SystemHolder struct {
+	system   atomic.Pointer[prover.ProvingSystem]
+	rejected atomic.Pointer[Error]
+}
+
+func NewSystemHolder() *SystemHolder {
+	return &SystemHolder{}
+}
+
+// Set makes the proving system available to the requests received from now on.
+func (holder *SystemHolder) Set(system *prover.ProvingSystem) {
+	holder.system.Store(system)
+}
+
+// Reject records why the proving system was not made available, for the
+// readiness probe to report it.
+func (holder *SystemHolder) Reject(err error) {
+	holder.rejected.Store(&Error{StatusCode: http.StatusServiceUnavailable, Code: "keys_rejected", Message: err.Error()})
+}
+
+// Get returns the current proving system, or nil if it is not loaded yet.
+func (holder *SystemHolder) Get() *prover.ProvingSystem {
+	return holder.system.Load()
+}
+
+func notReadyError() *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "not_ready", Message: "the proving system is not loaded yet"}
+}
+
+type statusJSON struct {
+	Status string `json:"status"`
+}
+
+// healthHandler reports that the process is alive and serving requests.
+type healthHandler struct{}
+
+func (handler healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, &statusJSON{Status: "ok"})
+}
+
+// readyHandler reports whether the server can take proving requests.
+type readyHandler struct {
+	system *SystemHolder
+	pool   *proverPool
+}
+
+func (handler readyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.system.Get() == nil {
+		if rejected := handler.system.rejected.Load(); rejected != nil {
+			rejected.send(w)
+			return
+		}
+		notReadyError().send(w)
+		return
+	}
+	if handler.pool.isDraining() {
+		shuttingDownError().send(w)
+		return
+	}
+	sendJSON(w, http.StatusOK, &statusJSON{Status: "ready"})
+}
+
+type infoJSON struct {
+	Mode             string `json:"mode"`
+	TreeDepth        uint32 `json:"treeDepth"`
+	BatchSize        uint32 `json:"batchSize"`
+	Constraints      int    `json:"constraints"`
+	Backend          string `json:"backend"`
+	Curve            string `json:"curve"`
+	VerifyingKeyHash string `json:"verifyingKeyHash"`
+}
+
+// infoHandler describes the loaded proving system, so that clients can check
+// that they target the right circuit and verifier contract.
+type infoHandler struct {
+	mode   string
+	system *SystemHolder
+}
+
+func (handler infoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	provingSystem := handler.system.Get()
+	if provingSystem == nil {
+		notReadyError().send(w)
+		return
+	}
+	info, err := newInfo(handler.mode, provingSystem)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	sendJSON(w, http.StatusOK, info)
+}
+
+func newInfo(mode string, provingSystem *prover.ProvingSystem) (*infoJSON, error) {
+	vkHash, err := provingSystem.VerifyingKeyHash()
+	if err != nil {
+		return nil, err
+	}
+	return &infoJSON{
+		Mode:             mode,
+		TreeDepth:        provingSystem.TreeDepth,
+		BatchSize:        provingSystem.BatchSize,
+		Constraints:      provingSystem.ConstraintSystem.GetNbConstraints(),
+		Backend:          "groth16",
+		Curve:            provingSystem.ProvingKey.CurveID().String(),
+		VerifyingKeyHash: hex.EncodeToString(vkHash),
+	}, nil
+}
@@ -1,39 +1,224 @@
 package server
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
 type RunningJob struct {
 	stop   chan struct{}
 	closed chan struct{}
+	failed chan struct{}
+	state  *jobState
+}
+
+// jobState is shared by the copies of a RunningJob. failure is written before
+// failed is closed, and shutdownErr before closed is closed, and they are only
+// read after that.
+type jobState struct {
+	stopOnce    sync.Once
+	failure     error
+	shutdownErr error
+}
+
+// JobError reports which job failed.
+type JobError struct {
+	Job string
+	Err error
 }
 
+func (err *JobError) Error() string {
+	return fmt.Sprintf("%s failed: %s", err.Job, err.Err)
+}
+
+func (err *JobError) Unwrap() error {
+	return err.Err
+}
+
+// RequestStop asks the job to shut down. It is safe to call several times.
 func (server *RunningJob) RequestStop() {
-	close(server.stop)
+	server.state.stopOnce.Do(func() { close(server.stop) })
 }
 
-func (server *RunningJob) AwaitStop() {
+// AwaitStop waits for the job to shut down, returning what went wrong while
+// doing so, if anything.
+func (server *RunningJob) AwaitStop() error {
 	<-server.closed
+	return server.state.shutdownErr
 }
 
-func SpawnJob(start func(), shutdown func()) RunningJob {
-	stop := make(chan struct{})
-	closed := make(chan struct{})
+// Failed is closed when the job stops on its own because of an error, which
+// is then returned by Err. The job still has to be stopped to release its
+// resources.
+func (server *RunningJob) Failed() <-chan struct{} {
+	return server.failed
+}
+
+// Err returns the error the job failed with, or nil if it has not failed.
+func (server *RunningJob) Err() error {
+	select {
+	case <-server.failed:
+		return server.state.failure
+	default:
+		return nil
+	}
+}
+
+// SpawnJob runs start in the background until shutdown is requested. An error
+// returned by start marks the job as failed, and is reported as a JobError
+// naming the job unless it already is one.
+func SpawnJob(name string, start func() error, shutdown func() error) RunningJob {
+	job := RunningJob{
+		stop:   make(chan struct{}),
+		closed: make(chan struct{}),
+		failed: make(chan struct{}),
+		state:  &jobState{},
+	}
 	go func() {
-		<-stop
-		shutdown()
-		close(closed)
+		<-job.stop
+		job.state.shutdownErr = shutdown()
+		close(job.closed)
 	}()
-	go start()
-	return RunningJob{stop: stop, closed: closed}
+	go func() {
+		err := start()
+		if err != nil {
+			var jobErr *JobError
+			if !errors.As(err, &jobErr) {
+				err = &JobError{Job: name, Err: err}
+			}
+			job.state.failure = err
+			close(job.failed)
+		}
+	}()
+	return job
+}
+
+// follow waits for the job to either fail, returning its error, or shut down,
+// returning nil.
+func follow(job RunningJob) error {
+	select {
+	case <-job.failed:
+		return job.Err()
+	case <-job.closed:
+		return job.Err()
+	}
 }
 
+// CombineJobs runs the jobs together. The combined job fails as soon as one
+// of them fails, and shutting it down shuts all of them down.
 func CombineJobs(jobs ...RunningJob) RunningJob {
-	start := func() {}
-	shutdown := func() {
+	start := func() error {
+		failures := make(chan error, len(jobs))
+		for _, job := range jobs {
+			go func(job RunningJob) { failures <- follow(job) }(job)
+		}
+		for range jobs {
+			err := <-failures
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	shutdown := func() error {
 		for _, job := range jobs {
 			job.RequestStop()
 		}
+		var errs []error
 		for _, job := range jobs {
-			job.AwaitStop()
+			errs = append(errs, job.AwaitStop())
+		}
+		return errors.Join(errs...)
+	}
+	return SpawnJob("combined jobs", start, shutdown)
+}
+
+// RestartPolicy tells a supervisor how many times in a row to restart a failed
+// job, and how long to wait before doing so. A job failing after running for
+// longer than the backoff counts as running fine in between, and is restarted
+// as if it failed for the first time. Without a backoff, the failures all
+// count. The zero value never restarts.
+type RestartPolicy struct {
+	MaxRestarts int
+	Backoff     time.Duration
+}
+
+var DefaultRestartPolicy = RestartPolicy{MaxRestarts: 3, Backoff: time.Second}
+
+// Supervise runs the job created by spawn, spawning a new one whenever it
+// fails, as allowed by policy. Once the restarts are used up, the supervised
+// job fails with the error of the last attempt.
+func Supervise(name string, spawn func() RunningJob, policy RestartPolicy) RunningJob {
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	var mutex sync.Mutex
+	stopping := false
+	var current *RunningJob
+
+	start := func() error {
+		defer close(exited)
+		for restarts := 0; ; restarts++ {
+			job := spawn()
+			spawned := time.Now()
+			mutex.Lock()
+			if stopping {
+				mutex.Unlock()
+				job.RequestStop()
+				job.AwaitStop()
+				return nil
+			}
+			current = &job
+			mutex.Unlock()
+
+			err := follow(job)
+			if err == nil {
+				return nil
+			}
+			mutex.Lock()
+			current = nil
+			mutex.Unlock()
+			job.RequestStop()
+			stopErr := job.AwaitStop()
+			if stopErr != nil {
+				logging.Logger().Error().Err(stopErr).Str("job", name).Msg("error when stopping failed job")
+			}
+			if policy.Backoff > 0 && time.Since(spawned) > policy.Backoff {
+				restarts = 0
+			}
+			if restarts >= policy.MaxRestarts {
+				var jobErr *JobError
+				if errors.As(err, &jobErr) {
+					err = jobErr.Err
+				}
+				if restarts > 0 {
+					err = fmt.Errorf("%w (gave up after %d restarts)", err, restarts)
+				}
+				return err
+			}
+			logging.Logger().Warn().Err(err).Str("job", name).Dur("backoff", policy.Backoff).Msg("restarting failed job")
+			select {
+			case <-time.After(policy.Backoff):
+			case <-stop:
+				return nil
+			}
+		}
+	}
+	shutdown := func() error {
+		mutex.Lock()
+		stopping = true
+		job := current
+		mutex.Unlock()
+		close(stop)
+		var err error
+		if job != nil {
+			job.RequestStop()
+			err = job.AwaitStop()
 		}
+		<-exited
+		return err
 	}
-	return SpawnJob(start, shutdown)
+	return SpawnJob(name, start, shutdown)
 }
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// ProofJob is the externally visible state of an asynchronous proving job.
+type ProofJob struct {
+	ID     string        `json:"id"`
+	Status JobStatus     `json:"status"`
+	Proof  *prover.Proof `json:"proof,omitempty"`
+	Error  *Error        `json:"error,omitempty"`
+}
+
+// finished tells whether the job is over, whatever its outcome.
+func (job *ProofJob) finished() bool {
+	return job.Status != JobQueued && job.Status != JobRunning
+}
+
+var ErrJobNotFound = errors.New("job not found")
+
+// JobRetention limits how long the stores keep finished jobs. Jobs that are
+// queued or running are always kept.
+type JobRetention struct {
+	// TTL is how long a job is kept once finished. Zero means no limit.
+	TTL time.Duration
+	// MaxFinished is the number of finished jobs kept, the ones that
+	// finished first being removed first. Zero means no limit.
+	MaxFinished int
+}
+
+const DefaultJobTTL = 24 * time.Hour
+
+// finishedJobs tracks when the jobs of a store finished, to tell which ones
+// are past the retention.
+type finishedJobs struct {
+	retention JobRetention
+	at        map[string]time.Time
+	// order holds the ids of the jobs, in the order they finished.
+	order []string
+}
+
+func newFinishedJobs(retention JobRetention) *finishedJobs {
+	return &finishedJobs{retention: retention, at: make(map[string]time.Time)}
+}
+
+func (jobs *finishedJobs) add(id string, at time.Time) {
+	jobs.remove(id)
+	jobs.at[id] = at
+	jobs.order = append(jobs.order, id)
+}
+
+func (jobs *finishedJobs) remove(id string) {
+	if _, ok := jobs.at[id]; !ok {
+		return
+	}
+	delete(jobs.at, id)
+	for i := range jobs.order {
+		if jobs.order[i] == id {
+			jobs.order = append(jobs.order[:i], jobs.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// expired tells whether the job is finished and past its time to live.
+func (jobs *finishedJobs) expired(id string, now time.Time) bool {
+	at, ok := jobs.at[id]
+	return ok && jobs.retention.TTL > 0 && now.Sub(at) >= jobs.retention.TTL
+}
+
+// prune forgets the jobs past the retention, returning their ids so that the
+// store removes them.
+func (jobs *finishedJobs) prune(now time.Time) []string {
+	var pruned []string
+	for len(jobs.order) > 0 {
+		oldest := jobs.order[0]
+		tooMany := jobs.retention.MaxFinished > 0 && len(jobs.order) > jobs.retention.MaxFinished
+		if !tooMany && !jobs.expired(oldest, now) {
+			break
+		}
+		delete(jobs.at, oldest)
+		jobs.order = jobs.order[1:]
+		pruned = append(pruned, oldest)
+	}
+	return pruned
+}
+
+// JobStore keeps track of asynchronous proving jobs and their results.
+type JobStore interface {
+	Put(job *ProofJob) error
+	Get(id string) (*ProofJob, error)
+	Delete(id string) error
+}
+
+type memoryJobStore struct {
+	mutex    sync.RWMutex
+	jobs     map[string]*ProofJob
+	finished *finishedJobs
+}
+
+// NewMemoryJobStore creates a store keeping the jobs in memory, removing the
+// finished ones past the retention.
+func NewMemoryJobStore(retention JobRetention) JobStore {
+	return &memoryJobStore{jobs: make(map[string]*ProofJob), finished: newFinishedJobs(retention)}
+}
+
+func (store *memoryJobStore) Put(job *ProofJob) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored := *job
+	store.jobs[job.ID] = &stored
+	now := time.Now()
+	if job.finished() {
+		store.finished.add(job.ID, now)
+	}
+	for _, id := range store.finished.prune(now) {
+		delete(store.jobs, id)
+	}
+	return nil
+}
+
+func (store *memoryJobStore) Get(id string) (*ProofJob, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	job, ok := store.jobs[id]
+	if !ok || store.finished.expired(id, time.Now()) {
+		return nil, ErrJobNotFound
+	}
+	result := *job
+	return &result, nil
+}
+
+func (store *memoryJobStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.jobs[id]; !ok {
+		return ErrJobNotFound
+	}
+	delete(store.jobs, id)
+	store.finished.remove(id)
+	return nil
+}
+
+// Job ids are used as file names by the disk store, so anything that is not
+// a plain hex string is rejected before touching the filesystem.
+var jobIdPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+
+type diskJobStore struct {
+	mutex    sync.RWMutex
+	dir      string
+	finished *finishedJobs
+}
+
+// NewDiskJobStore creates a store keeping every job as a JSON file in dir,
+// removing the finished ones past the retention. The directory is created if
+// it does not exist. The jobs already in it are kept as if they finished when
+// their file was last written. The ones left queued or running by a previous
+// process are marked as cancelled by its shutdown, and the files that are not
+// valid jobs are moved aside.
+func NewDiskJobStore(dir string, retention JobRetention) (JobStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	store := &diskJobStore{dir: dir, finished: newFinishedJobs(retention)}
+	err = store.load()
+	if err != nil {
+		return nil, err
+	}
+	return store, store.prune(time.Now())
+}
+
+// load tracks the finished jobs found in the directory, finishing the others.
+func (store *diskJobStore) load() error {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+	type found struct {
+		id string
+		at time.Time
+	}
+	var jobs []found
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !jobIdPattern.MatchString(id) {
+			continue
+		}
+		path := filepath.Join(store.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var job ProofJob
+		err = json.Unmarshal(data, &job)
+		if err != nil || job.ID != id {
+			logging.Logger().Error().Err(err).Str("job", id).Msg("moving aside corrupted job file")
+			err = os.Rename(path, path+".corrupted")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !job.finished() {
+			logging.Logger().Info().Str("job", id).Msg("cancelling prove job left unfinished by a previous process")
+			err = store.write(path, &ProofJob{ID: id, Status: JobCancelled, Error: shuttingDownError()})
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, found{id, time.Now()})
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, found{id, info.ModTime()})
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].at.Before(jobs[j].at) })
+	for _, job := range jobs {
+		store.finished.add(job.id, job.at)
+	}
+	return nil
+}
+
+// prune removes the files of the jobs past the retention. It must be called
+// with the mutex held.
+func (store *diskJobStore) prune(now time.Time) error {
+	for _, id := range store.finished.prune(now) {
+		err := os.Remove(filepath.Join(store.dir, id+".json"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *diskJobStore) path(id string) (string, error) {
+	if !jobIdPattern.MatchString(id) {
+		return "", ErrJobNotFound
+	}
+	return filepath.Join(store.dir, id+".json"), nil
+}
+
+func (store *diskJobStore) Put(job *ProofJob) error {
+	path, err := store.path(job.ID)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	err = store.write(path, job)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if job.finished() {
+		store.finished.add(job.ID, now)
+	}
+	return store.prune(now)
+}
+
+// write writes the job to its file. It must be called with the mutex held.
+func (store *diskJobStore) write(path string, job *ProofJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that readers never observe a
+	// partially written job.
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (store *diskJobStore) Get(id string) (*ProofJob, error) {
+	path, err := store.path(id)
+	if err != nil {
+		return nil, err
+	}
+	store.mutex.RLock()
+	data, err := os.ReadFile(path)
+	expired := store.finished.expired(id, time.Now())
+	store.mutex.RUnlock()
+	if errors.Is(err, os.ErrNotExist) || expired {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job ProofJob
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+func (store *diskJobStore) Delete(id string) error {
+	path, err := store.path(id)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.finished.remove(id)
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrJobNotFound
+	}
+	return err
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
+func jobNotFoundError(id string) *Error {
+	return &Error{StatusCode: http.StatusNotFound, Code: "job_not_found", Message: "no job with id " + id}
+}
+
+// jobsHandler serves the asynchronous proving API:
+//
+//	POST   /jobs      submits a proving job and returns its id
+//	GET    /jobs/{id} returns the status of the job and its result once finished
+//	DELETE /jobs/{id} cancels a queued or running job or forgets a finished one
+type jobsHandler struct {
+	mode           string
+	system         *SystemHolder
+	store          JobStore
+	pool           *proverPool
+	maxProvingTime time.Duration
+
+	// pending holds the jobs that are queued or whose proofs are still being
+	// generated, along with the functions cancelling their contexts. A job
+	// cancelled in the meantime is removed from this map, so that it is
+	// skipped or its result is discarded.
+	mutex   sync.Mutex
+	pending map[string]context.CancelFunc
+	// changed is closed and replaced every time a job is updated.
+	changed chan struct{}
+}
+
+func newJobsHandler(mode string, system *SystemHolder, store JobStore, pool *proverPool, maxProvingTime time.Duration) *jobsHandler {
+	return &jobsHandler{
+		mode:           mode,
+		system:         system,
+		store:          store,
+		pool:           pool,
+		maxProvingTime: maxProvingTime,
+		pending:        make(map[string]context.CancelFunc),
+		changed:        make(chan struct{}),
+	}
+}
+
+func newJobId() (string, error) {
+	var id [16]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+func (handler *jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	if id == "" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		handler.submit(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		handler.status(w, id)
+	case http.MethodDelete:
+		handler.cancel(w, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *jobsHandler) submit(w http.ResponseWriter, r *http.Request) {
+	logging.Logger().Info().Msg("received prove job")
+	provingSystem := handler.system.Get()
+	if provingSystem == nil {
+		notReadyError().send(w)
+		return
+	}
+
+	request, requestErr := readProofRequest(handler.mode, provingSystem, r)
+	if requestErr != nil {
+		requestErr.send(w)
+		return
+	}
+
+	// Jobs outlive the request submitting them, so their context is not
+	// derived from the one of the request.
+	ctx, cancel, timeoutErr := proveContext(context.Background(), r, handler.maxProvingTime)
+	if timeoutErr != nil {
+		timeoutErr.send(w)
+		return
+	}
+	job, submitErr := handler.enqueue(ctx, cancel, request)
+	if submitErr != nil {
+		submitErr.send(w)
+		return
+	}
+	sendJSON(w, http.StatusAccepted, job)
+}
+
+// enqueue submits the request as a new job running with ctx, which cancel
+// cancels once the job is over.
+func (handler *jobsHandler) enqueue(ctx context.Context, cancel context.CancelFunc, request *proofRequest) (*ProofJob, *Error) {
+	id, err := newJobId()
+	if err != nil {
+		cancel()
+		return nil, unexpectedError(err)
+	}
+	job := &ProofJob{ID: id, Status: JobQueued}
+
+	handler.mutex.Lock()
+	submitErr := handler.pool.submit(ctx, func(ctx context.Context) { handler.run(ctx, job, request) })
+	if submitErr == nil {
+		// The job cannot be picked up by a worker before the lock is
+		// released, so it is safe to store it only now.
+		handler.pending[id] = cancel
+		err = handler.put(job)
+		if err != nil {
+			delete(handler.pending, id)
+		}
+	}
+	handler.mutex.Unlock()
+	if submitErr != nil || err != nil {
+		cancel()
+	}
+	if submitErr != nil {
+		return nil, handler.pool.submitError(submitErr)
+	}
+	if err != nil {
+		return nil, unexpectedError(err)
+	}
+
+	logging.Logger().Info().Str("job", id).Msg("prove job submitted")
+	return job, nil
+}
+
+// put stores the job and wakes up the watchers of jobs. It must be called with
+// the mutex held.
+func (handler *jobsHandler) put(job *ProofJob) error {
+	err := handler.store.Put(job)
+	close(handler.changed)
+	handler.changed = make(chan struct{})
+	return err
+}
+
+// changes returns a channel closed the next time a job is updated.
+func (handler *jobsHandler) changes() <-chan struct{} {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.changed
+}
+
+// watch calls send with the job every time its status changes, until it has
+// finished or ctx is done.
+func (handler *jobsHandler) watch(ctx context.Context, id string, send func(job *ProofJob) error) error {
+	var last JobStatus
+	for {
+		changed := handler.changes()
+		job, err := handler.store.Get(id)
+		if err != nil {
+			return err
+		}
+		if job.Status != last {
+			err = send(job)
+			if err != nil {
+				return err
+			}
+			last = job.Status
+		}
+		if job.finished() {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (handler *jobsHandler) run(ctx context.Context, job *ProofJob, request *proofRequest) {
+	if !handler.markRunning(job.ID) {
+		logging.Logger().Info().Str("job", job.ID).Msg("skipping cancelled prove job")
+		return
+	}
+
+	proof, proveErr := request.prove(ctx, handler.system.Get())
+
+	result := &ProofJob{ID: job.ID, Status: JobCompleted, Proof: proof}
+	if proveErr != nil {
+		requestErrors.WithLabelValues(proveErr.Code).Inc()
+		result = &ProofJob{ID: job.ID, Status: JobFailed, Error: proveErr}
+	}
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	cancel, ok := handler.pending[job.ID]
+	if !ok {
+		logging.Logger().Info().Str("job", job.ID).Msg("discarding result of cancelled prove job")
+		return
+	}
+	cancel()
+	delete(handler.pending, job.ID)
+	err := handler.put(result)
+	if err != nil {
+		logging.Logger().Error().Err(err).Str("job", job.ID).Msg("error storing prove job result")
+		return
+	}
+	logging.Logger().Info().Str("job", job.ID).Str("status", string(result.Status)).Msg("prove job finished")
+}
+
+// markRunning updates the status of a queued job, returning false if it has
+// been cancelled in the meantime.
+func (handler *jobsHandler) markRunning(id string) bool {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	if _, ok := handler.pending[id]; !ok {
+		return false
+	}
+	err := handler.put(&ProofJob{ID: id, Status: JobRunning})
+	if err != nil {
+		logging.Logger().Error().Err(err).Str("job", id).Msg("error storing prove job status")
+	}
+	return true
+}
+
+func (handler *jobsHandler) status(w http.ResponseWriter, id string) {
+	job, err := handler.store.Get(id)
+	if errors.Is(err, ErrJobNotFound) {
+		jobNotFoundError(id).send(w)
+		return
+	}
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	sendJSON(w, http.StatusOK, job)
+}
+
+func (handler *jobsHandler) cancel(w http.ResponseWriter, id string) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	if cancel, ok := handler.pending[id]; ok {
+		// The prover stops at the next point where it checks the context,
+		// and whatever it returns is dropped.
+		cancel()
+		delete(handler.pending, id)
+		job := &ProofJob{ID: id, Status: JobCancelled}
+		err := handler.put(job)
+		if err != nil {
+			unexpectedError(err).send(w)
+			return
+		}
+		logging.Logger().Info().Str("job", id).Msg("prove job cancelled")
+		sendJSON(w, http.StatusOK, job)
+		return
+	}
+
+	err := handler.store.Delete(id)
+	if errors.Is(err, ErrJobNotFound) {
+		jobNotFoundError(id).send(w)
+		return
+	}
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cancelPending marks all the jobs that have not finished yet as cancelled
+// because of the server shutting down, returning their number.
+func (handler *jobsHandler) cancelPending() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	cancelled := 0
+	for id, cancel := range handler.pending {
+		cancel()
+		delete(handler.pending, id)
+		err := handler.put(&ProofJob{ID: id, Status: JobCancelled, Error: shuttingDownError()})
+		if err != nil {
+			logging.Logger().Error().Err(err).Str("job", id).Msg("error storing prove job status")
+		}
+		cancelled++
+	}
+	return cancelled
+}
+
+func sendJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	responseBytes, err := json.Marshal(value)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.WriteHeader(statusCode)
+	_, err = w.Write(responseBytes)
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "prover_queue_length",
+		Help:      "Number of proving requests waiting for a free worker.",
+	})
+	queueWaitTime = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mtb",
+		Name:      "prover_queue_wait_seconds",
+		Help:      "Time proving requests spent waiting for a free worker.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mtb",
+		Name:      "request_errors_total",
+		Help:      "Number of proving requests that failed, by error code.",
+	}, []string{"code"})
+	rejectedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "mtb",
+		Name:      "prover_queue_rejected_total",
+		Help:      "Number of proving requests rejected because the queue was full.",
+	})
+	selfTests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mtb",
+		Name:      "self_tests_total",
+		Help:      "Number of self-test proofs run on proving systems, by result.",
+	}, []string{"result"})
+	selfTestDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mtb",
+		Name:      "self_test_duration_seconds",
+		Help:      "Time the last self-test took to prove and verify its batch.",
+	})
+)
+
+func init() {
+	// Make the common error codes show up before the first failure.
+	for _, code := range []string{"malformed_body", "invalid_field_element", "invalid_parameters", "proving_error", "unexpected_error"} {
+		requestErrors.WithLabelValues(code)
+	}
+	for _, result := range []string{"passed", "failed"} {
+		selfTests.WithLabelValues(result)
+	}
+}
@@ -0,0 +1,4 @@
+// Package pb holds the protocol buffers of the gRPC API of the prover server.
+package pb
+
+//go:generate buf generate
@@ -0,0 +1,303 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: prover.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Prover_Prove_FullMethodName     = "/mtb.v1.Prover/Prove"
+	Prover_SubmitJob_FullMethodName = "/mtb.v1.Prover/SubmitJob"
+	Prover_WatchJob_FullMethodName  = "/mtb.v1.Prover/WatchJob"
+	Prover_Verify_FullMethodName    = "/mtb.v1.Prover/Verify"
+	Prover_GetInfo_FullMethodName   = "/mtb.v1.Prover/GetInfo"
+)
+
+// ProverClient is the client API for Prover service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ProverClient interface {
+	// Prove generates a proof and returns it once done. The deadline of the
+	// call is applied to the proof, capped at the maximum proving time of the
+	// server.
+	Prove(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*ProveResponse, error)
+	// SubmitJob submits an asynchronous proving job.
+	SubmitJob(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*Job, error)
+	// WatchJob streams the state of a job every time it changes, ending once
+	// the job has finished.
+	WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (Prover_WatchJobClient, error)
+	// Verify checks a proof against its input hash, or against the one
+	// computed from the full parameters of the batch.
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// GetInfo describes the loaded proving system.
+	GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error)
+}
+
+type proverClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProverClient(cc grpc.ClientConnInterface) ProverClient {
+	return &proverClient{cc}
+}
+
+func (c *proverClient) Prove(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*ProveResponse, error) {
+	out := new(ProveResponse)
+	err := c.cc.Invoke(ctx, Prover_Prove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proverClient) SubmitJob(ctx context.Context, in *ProveRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Prover_SubmitJob_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proverClient) WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (Prover_WatchJobClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Prover_ServiceDesc.Streams[0], Prover_WatchJob_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proverWatchJobClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Prover_WatchJobClient interface {
+	Recv() (*Job, error)
+	grpc.ClientStream
+}
+
+type proverWatchJobClient struct {
+	grpc.ClientStream
+}
+
+func (x *proverWatchJobClient) Recv() (*Job, error) {
+	m := new(Job)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *proverClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, Prover_Verify_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proverClient) GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error) {
+	out := new(GetInfoResponse)
+	err := c.cc.Invoke(ctx, Prover_GetInfo_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ProverServer is the server API for Prover service.
+// All implementations must embed UnimplementedProverServer
+// for forward compatibility
+type ProverServer interface {
+	// Prove generates a proof and returns it once done. The deadline of the
+	// call is applied to the proof, capped at the maximum proving time of the
+	// server.
+	Prove(context.Context, *ProveRequest) (*ProveResponse, error)
+	// SubmitJob submits an asynchronous proving job.
+	SubmitJob(context.Context, *ProveRequest) (*Job, error)
+	// WatchJob streams the state of a job every time it changes, ending once
+	// the job has finished.
+	WatchJob(*WatchJobRequest, Prover_WatchJobServer) error
+	// Verify checks a proof against its input hash, or against the one
+	// computed from the full parameters of the batch.
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// GetInfo describes the loaded proving system.
+	GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error)
+	mustEmbedUnimplementedProverServer()
+}
+
+// UnimplementedProverServer must be embedded to have forward compatible implementations.
+type UnimplementedProverServer struct {
+}
+
+func (UnimplementedProverServer) Prove(context.Context, *ProveRequest) (*ProveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prove not implemented")
+}
+func (UnimplementedProverServer) SubmitJob(context.Context, *ProveRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitJob not implemented")
+}
+func (UnimplementedProverServer) WatchJob(*WatchJobRequest, Prover_WatchJobServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedProverServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedProverServer) GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInfo not implemented")
+}
+func (UnimplementedProverServer) mustEmbedUnimplementedProverServer() {}
+
+// UnsafeProverServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProverServer will
+// result in compilation errors.
+type UnsafeProverServer interface {
+	mustEmbedUnimplementedProverServer()
+}
+
+func RegisterProverServer(s grpc.ServiceRegistrar, srv ProverServer) {
+	s.RegisterService(&Prover_ServiceDesc, srv)
+}
+
+func _Prover_Prove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProverServer).Prove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Prover_Prove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProverServer).Prove(ctx, req.(*ProveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Prover_SubmitJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProverServer).SubmitJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Prover_SubmitJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProverServer).SubmitJob(ctx, req.(*ProveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Prover_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProverServer).WatchJob(m, &proverWatchJobServer{stream})
+}
+
+type Prover_WatchJobServer interface {
+	Send(*Job) error
+	grpc.ServerStream
+}
+
+type proverWatchJobServer struct {
+	grpc.ServerStream
+}
+
+func (x *proverWatchJobServer) Send(m *Job) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Prover_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProverServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Prover_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProverServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Prover_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProverServer).GetInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Prover_GetInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProverServer).GetInfo(ctx, req.(*GetInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Prover_ServiceDesc is the grpc.ServiceDesc for Prover service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Prover_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mtb.v1.Prover",
+	HandlerType: (*ProverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Prove",
+			Handler:    _Prover_Prove_Handler,
+		},
+		{
+			MethodName: "SubmitJob",
+			Handler:    _Prover_SubmitJob_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _Prover_Verify_Handler,
+		},
+		{
+			MethodName: "GetInfo",
+			Handler:    _Prover_GetInfo_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchJob",
+			Handler:       _Prover_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "prover.proto",
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultProverWorkers = 1
+const DefaultQueueDepth = 8
+
+var errQueueFull = errors.New("queue full")
+var errDraining = errors.New("draining")
+
+type proverTask struct {
+	ctx      context.Context
+	run      func(ctx context.Context)
+	enqueued time.Time
+}
+
+// proverPool runs proving tasks on a fixed number of workers. Tasks that
+// cannot start immediately wait in a bounded queue, and are rejected once the
+// queue is full so that bursts of requests cannot exhaust the memory of the
+// node.
+type proverPool struct {
+	queue   chan *proverTask
+	workers int
+	done    sync.WaitGroup
+
+	mutex    sync.RWMutex
+	draining bool
+	// pending counts the tasks that are queued or running.
+	pending atomic.Int64
+
+	// abandon is cancelled once the pool gives up on the tasks it has not
+	// finished, see abandon.
+	abandonCtx context.Context
+	abandon    context.CancelFunc
+
+	// averageRunTime is an exponential moving average of the time it takes a
+	// task to run, used to suggest when rejected clients should retry.
+	statsMutex     sync.Mutex
+	averageRunTime time.Duration
+}
+
+func newProverPool(workers int, queueDepth int) *proverPool {
+	if workers <= 0 {
+		workers = DefaultProverWorkers
+	}
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+	abandonCtx, abandon := context.WithCancel(context.Background())
+	return &proverPool{
+		queue:      make(chan *proverTask, queueDepth),
+		workers:    workers,
+		abandonCtx: abandonCtx,
+		abandon:    abandon,
+	}
+}
+
+func (pool *proverPool) start() {
+	for i := 0; i < pool.workers; i++ {
+		pool.done.Add(1)
+		go pool.work()
+	}
+}
+
+func (pool *proverPool) work() {
+	defer pool.done.Done()
+	for task := range pool.queue {
+		queueLength.Set(float64(len(pool.queue)))
+		queueWaitTime.Observe(time.Since(task.enqueued).Seconds())
+
+		if pool.abandonCtx.Err() == nil {
+			ctx, cancel := pool.taskContext(task.ctx)
+			started := time.Now()
+			task.run(ctx)
+			pool.recordRunTime(time.Since(started))
+			cancel()
+		}
+		pool.pending.Add(-1)
+	}
+}
+
+// taskContext derives the context a task runs with, which is also done once
+// the pool abandons its tasks.
+func (pool *proverPool) taskContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-pool.abandoned():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+	}
+}
+
+func (pool *proverPool) recordRunTime(runTime time.Duration) {
+	pool.statsMutex.Lock()
+	defer pool.statsMutex.Unlock()
+	if pool.averageRunTime == 0 {
+		pool.averageRunTime = runTime
+	} else {
+		pool.averageRunTime = (3*pool.averageRunTime + runTime) / 4
+	}
+}
+
+// submit queues the task, which runs with a context derived from ctx. It fails
+// with errQueueFull if there is no room left in the queue, and with
+// errDraining once the pool stopped accepting tasks.
+func (pool *proverPool) submit(ctx context.Context, run func(ctx context.Context)) error {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	if pool.draining {
+		return errDraining
+	}
+	pool.pending.Add(1)
+	select {
+	case pool.queue <- &proverTask{ctx: ctx, run: run, enqueued: time.Now()}:
+		queueLength.Set(float64(len(pool.queue)))
+		return nil
+	default:
+		pool.pending.Add(-1)
+		rejectedRequests.Inc()
+		return errQueueFull
+	}
+}
+
+// submitError converts an error returned by submit to the response sent to the
+// client.
+func (pool *proverPool) submitError(err error) *Error {
+	if errors.Is(err, errQueueFull) {
+		return queueFullError(pool.retryAfter())
+	}
+	return shuttingDownError()
+}
+
+// retryAfter estimates the number of seconds until the queue has room for
+// another task.
+func (pool *proverPool) retryAfter() int {
+	pool.statsMutex.Lock()
+	averageRunTime := pool.averageRunTime
+	pool.statsMutex.Unlock()
+	rounds := float64(len(pool.queue)+1) / float64(pool.workers)
+	seconds := int(math.Ceil(rounds * averageRunTime.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func (pool *proverPool) isDraining() bool {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	return pool.draining
+}
+
+// drain stops accepting new tasks and waits for the already submitted ones to
+// finish. If they do not finish before ctx is done, the pool abandons them:
+// the tasks still queued are skipped, the contexts of the running ones are
+// cancelled, and abandoned() is closed so that whoever waits for a task can
+// stop doing so. Returns the number of abandoned tasks.
+func (pool *proverPool) drain(ctx context.Context) int64 {
+	pool.mutex.Lock()
+	if !pool.draining {
+		pool.draining = true
+		close(pool.queue)
+	}
+	pool.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		pool.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return 0
+	case <-ctx.Done():
+		abandoned := pool.pending.Load()
+		pool.abandon()
+		return abandoned
+	}
+}
+
+// abandoned is closed once the pool gives up on the tasks it has not finished.
+func (pool *proverPool) abandoned() <-chan struct{} {
+	return pool.abandonCtx.Done()
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"worldcoin/gnark-mbu/prover"
+)
+
+const DefaultKeysFilePollInterval = 5 * time.Second
+
+// SelfTest proves and verifies a synthetic batch in the given mode, checking
+// that the proving system is usable for it. The outcome and the time it took
+// are logged and recorded in the metrics.
+func SelfTest(mode string, provingSystem *prover.ProvingSystem) error {
+	start := time.Now()
+	var err error
+	if mode == InsertionMode {
+		err = provingSystem.SelfTestInsertion()
+	} else if mode == DeletionMode {
+		err = provingSystem.SelfTestDeletion()
+	} else {
+		return fmt.Errorf("invalid mode: %s", mode)
+	}
+	duration := time.Since(start)
+	selfTestDuration.Set(duration.Seconds())
+	if err != nil {
+		selfTests.WithLabelValues("failed").Inc()
+		logging.Logger().Error().Err(err).Str("mode", mode).Dur("took", duration).Msg("self-test failed")
+		return err
+	}
+	selfTests.WithLabelValues("passed").Inc()
+	logging.Logger().Info().Str("mode", mode).Dur("took", duration).Msg("self-test passed")
+	return nil
+}
+
+// KeysReloader replaces the proving system used by the server with the one
+// read from the keys file, after checking that it can serve the same requests.
+type KeysReloader struct {
+	mode      string
+	path      string
+	system    *SystemHolder
+	checkKeys bool
+	trust     *prover.KeysTrust
+
+	mutex sync.Mutex
+}
+
+// NewKeysReloader returns a reloader running the self-test on new proving
+// systems, and CheckKeys too if checkKeys is set. With trust, the keys files
+// must be signed by one of its keys.
+func NewKeysReloader(mode string, path string, system *SystemHolder, checkKeys bool, trust *prover.KeysTrust) *KeysReloader {
+	return &KeysReloader{mode: mode, path: path, system: system, checkKeys: checkKeys, trust: trust}
+}
+
+// Reload reads and validates the keys file and swaps it in for new requests.
+// Requests already being served finish on the previous proving system. The
+// current proving system is kept if the new one is rejected.
+func (reloader *KeysReloader) Reload() error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	current := reloader.system.Get()
+	if current == nil {
+		return fmt.Errorf("the proving system is not loaded yet")
+	}
+
+	logging.Logger().Info().Str("path", reloader.path).Msg("reloading proving system")
+	ps, err := reloader.trust.ReadSystemFromFile(reloader.path)
+	if err != nil {
+		return err
+	}
+	if ps.TreeDepth != current.TreeDepth || ps.BatchSize != current.BatchSize {
+		return fmt.Errorf(
+			"proving system shape changed from depth %d and batch size %d to depth %d and batch size %d",
+			current.TreeDepth, current.BatchSize, ps.TreeDepth, ps.BatchSize,
+		)
+	}
+	if reloader.checkKeys {
+		logging.Logger().Info().Msg("checking keys of the new proving system")
+		if err := ps.CheckKeys(); err != nil {
+			return fmt.Errorf("new proving system has invalid keys: %w", err)
+		}
+	}
+	logging.Logger().Info().Msg("running self-test on the new proving system")
+	err = SelfTest(reloader.mode, ps)
+	if err != nil {
+		return fmt.Errorf("new proving system is not valid for %s mode: %w", reloader.mode, err)
+	}
+
+	reloader.system.Set(ps)
+	logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("proving system reloaded")
+	return nil
+}
+
+// Watch polls the keys file and reloads it whenever it changes. A change is
+// only acted on once the file has stayed the same for a whole interval, so
+// that a file still being written is not picked up.
+func (reloader *KeysReloader) Watch(interval time.Duration) RunningJob {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	start := func() error {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last, err := os.Stat(reloader.path)
+		if err != nil {
+			logging.Logger().Error().Err(err).Msg("error watching keys file")
+		}
+		var pending os.FileInfo
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-ticker.C:
+			}
+			info, err := os.Stat(reloader.path)
+			if err != nil {
+				logging.Logger().Error().Err(err).Msg("error watching keys file")
+				continue
+			}
+			if pending != nil && sameFileState(pending, info) {
+				pending = nil
+				last = info
+				err = reloader.Reload()
+				if err != nil {
+					logging.Logger().Error().Err(err).Msg("error reloading proving system")
+				}
+				continue
+			}
+			if last == nil || !sameFileState(last, info) {
+				pending = info
+			} else {
+				pending = nil
+			}
+		}
+	}
+	shutdown := func() error {
+		close(stop)
+		<-stopped
+		return nil
+	}
+	return SpawnJob("keys file watcher", start, shutdown)
+}
+
+func sameFileState(a os.FileInfo, b os.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -3,20 +3,47 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 	"worldcoin/gnark-mbu/logging"
 
 	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/server/pb"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 )
 
 type Error struct {
 	StatusCode int
 	Code       string
 	Message    string
+	// RetryAfter is sent as the Retry-After header, in seconds, when set.
+	RetryAfter int
+	// Details tells which part of the parameters is invalid, when known.
+	Details *ErrorDetails
+}
+
+// ErrorDetails pinpoints what is wrong with the parameters of a request.
+//
+// For an invalid field element, Path is the JSON path of the value.
+//
+// For the first check that fails when replaying the rounds of a batch, Slot is
+// the failing round, omitted when the failure is not about a single round.
+// Expected and Actual are the roots before and after a failing Merkle proof,
+// the expected and given input hash or post-root, or the index out of range.
+type ErrorDetails struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path,omitempty"`
+	Slot     *int   `json:"slot,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
 }
 
 const DeletionMode = "deletion"
@@ -30,18 +57,98 @@ func provingError(err error) *Error {
 	return &Error{StatusCode: http.StatusBadRequest, Code: "proving_error", Message: err.Error()}
 }
 
+// decodeError reports an error decoding the JSON of a request, with the paths of
+// invalid field elements nested under prefix.
+func decodeError(err error, prefix string) *Error {
+	var fieldErr *prover.FieldElementError
+	if !errors.As(err, &fieldErr) {
+		return malformedBodyError(err)
+	}
+	nested := *fieldErr
+	nested.Path = prefix + fieldErr.Path
+	return &Error{
+		StatusCode: http.StatusBadRequest,
+		Code:       "invalid_field_element",
+		Message:    nested.Error(),
+		Details:    &ErrorDetails{Kind: nested.Kind, Path: nested.Path},
+	}
+}
+
+func invalidParametersError(err *prover.ValidationError) *Error {
+	details := &ErrorDetails{Kind: err.Kind}
+	if err.Slot >= 0 {
+		slot := err.Slot
+		details.Slot = &slot
+	}
+	if err.Expected != nil {
+		details.Expected = fmt.Sprintf("0x%s", err.Expected.Text(16))
+	}
+	if err.Actual != nil {
+		details.Actual = fmt.Sprintf("0x%s", err.Actual.Text(16))
+	}
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_parameters", Message: err.Error(), Details: details}
+}
+
+func queueFullError(retryAfter int) *Error {
+	return &Error{
+		StatusCode: http.StatusTooManyRequests,
+		Code:       "queue_full",
+		Message:    fmt.Sprintf("the proving queue is full, retry in %d seconds", retryAfter),
+		RetryAfter: retryAfter,
+	}
+}
+
+func shuttingDownError() *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "shutting_down", Message: "the server is shutting down"}
+}
+
+func deadlineExceededError() *Error {
+	return &Error{StatusCode: http.StatusGatewayTimeout, Code: "deadline_exceeded", Message: "the proof was not generated before the deadline"}
+}
+
+func cancelledError() *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "cancelled", Message: "proof generation was cancelled"}
+}
+
+func invalidTimeoutError(value string) *Error {
+	return &Error{
+		StatusCode: http.StatusBadRequest,
+		Code:       "invalid_timeout",
+		Message:    fmt.Sprintf("invalid %s header %q, expected a positive duration such as 90s", ProveTimeoutHeader, value),
+	}
+}
+
 func unexpectedError(err error) *Error {
 	return &Error{StatusCode: http.StatusInternalServerError, Code: "unexpected_error", Message: err.Error()}
 }
 
+type errorJSON struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details *ErrorDetails `json:"details,omitempty"`
+}
+
 func (error *Error) MarshalJSON() ([]byte, error) {
-	return json.Marshal(map[string]string{
-		"code":    error.Code,
-		"message": error.Message,
-	})
+	return json.Marshal(errorJSON{Code: error.Code, Message: error.Message, Details: error.Details})
+}
+
+func (error *Error) UnmarshalJSON(data []byte) error {
+	var fields errorJSON
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	error.Code = fields.Code
+	error.Message = fields.Message
+	error.Details = fields.Details
+	return nil
 }
 
 func (error *Error) send(w http.ResponseWriter) {
+	requestErrors.WithLabelValues(error.Code).Inc()
+	if error.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(error.RetryAfter))
+	}
 	w.WriteHeader(error.StatusCode)
 	jsonBytes, err := error.MarshalJSON()
 	if err != nil {
@@ -57,45 +164,192 @@ type Config struct {
 	ProverAddress  string
 	MetricsAddress string
 	Mode           string
+	// JobStore keeps the results of asynchronous proving jobs. An in-memory
+	// store is used when it is not set.
+	JobStore JobStore
+	// JobRetention limits how long the in-memory store used when JobStore is
+	// not set keeps finished jobs. A JobStore is created with its own.
+	JobRetention JobRetention
+	// ProverWorkers is the number of proofs generated concurrently, defaults
+	// to DefaultProverWorkers.
+	ProverWorkers int
+	// QueueDepth is the number of requests waiting for a free worker before
+	// new ones are rejected.
+	QueueDepth int
+	// DrainTimeout is how long the server waits for the proofs in flight to
+	// finish when shutting down, defaults to DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// MaxProvingTime caps the time a proving request may take, including the
+	// time spent queued. It is also the deadline of the requests that do not
+	// set one. Zero means no limit.
+	MaxProvingTime time.Duration
+	// RestartPolicy tells how often the servers are restarted after failing,
+	// for example because their address is in use. They are not restarted by
+	// default.
+	RestartPolicy RestartPolicy
+	// GrpcAddress is the address of the gRPC server, which is only started
+	// when it is set.
+	GrpcAddress string
 }
 
+// ProveTimeoutHeader sets the deadline of a proving request, as a duration
+// such as 90s, counted from when the request is received.
+const ProveTimeoutHeader = "X-Prove-Timeout"
+
+const DefaultDrainTimeout = 30 * time.Second
+
+// ErrDrainTimeout is reported when the server shut down before all the proofs
+// in flight finished.
+var ErrDrainTimeout = errors.New("drain timeout exceeded")
+
 func spawnServerJob(server *http.Server, label string) RunningJob {
-	start := func() {
+	start := func() error {
 		err := server.ListenAndServe()
 		if err != nil && err != http.ErrServerClosed {
-			panic(fmt.Sprintf("%s failed: %s", label, err))
+			return err
 		}
+		return nil
 	}
-	shutdown := func() {
+	shutdown := func() error {
 		logging.Logger().Info().Msgf("shutting down %s", label)
 		err := server.Shutdown(context.Background())
 		if err != nil {
 			logging.Logger().Error().Err(err).Msgf("error when shutting down %s", label)
+			return fmt.Errorf("shutting down %s: %w", label, err)
 		}
 		logging.Logger().Info().Msgf("%s shut down", label)
+		return nil
+	}
+	return SpawnJob(label, start, shutdown)
+}
+
+// superviseServer runs the server built by newServer, building a new one each
+// time it has to be restarted, since a server cannot be reused once shut down.
+func superviseServer(newServer func() *http.Server, label string, policy RestartPolicy) RunningJob {
+	spawn := func() RunningJob {
+		return spawnServerJob(newServer(), label)
 	}
-	return SpawnJob(start, shutdown)
+	return Supervise(label, spawn, policy)
+}
+
+// spawnProverServerJob runs the prover server, draining the proving pool when
+// shut down. New work is rejected while draining, and the work still in
+// flight is abandoned once the drain timeout passes.
+func spawnProverServerJob(job RunningJob, pool *proverPool, jobs *jobsHandler, drainTimeout time.Duration) RunningJob {
+	start := func() error {
+		return follow(job)
+	}
+	shutdown := func() error {
+		logging.Logger().Info().Dur("timeout", drainTimeout).Msg("draining proving pool")
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		abandoned := pool.drain(ctx)
+		var drainErr error
+		if abandoned > 0 {
+			cancelled := jobs.cancelPending()
+			drainErr = fmt.Errorf("%w: abandoned %d proofs, including %d jobs", ErrDrainTimeout, abandoned, cancelled)
+			logging.Logger().Warn().Int64("abandoned", abandoned).Int("cancelledJobs", cancelled).Msg("drain timeout exceeded")
+		} else {
+			logging.Logger().Info().Msg("proving pool drained")
+		}
+		job.RequestStop()
+		return errors.Join(drainErr, job.AwaitStop())
+	}
+	return SpawnJob("prover server", start, shutdown)
 }
 
 func Run(config *Config, provingSystem *prover.ProvingSystem) RunningJob {
+	system := NewSystemHolder()
+	system.Set(provingSystem)
+	return RunWithSystem(config, system)
+}
+
+// RunWithSystem starts the servers without waiting for the proving system to
+// be available. Until it is set on the holder, proving requests are rejected
+// as not ready.
+func RunWithSystem(config *Config, system *SystemHolder) RunningJob {
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", promhttp.Handler())
-	metricsServer := &http.Server{Addr: config.MetricsAddress, Handler: metricsMux}
-	metricsJob := spawnServerJob(metricsServer, "metrics server")
+	newMetricsServer := func() *http.Server {
+		return &http.Server{Addr: config.MetricsAddress, Handler: metricsMux}
+	}
+	metricsJob := superviseServer(newMetricsServer, "metrics server", config.RestartPolicy)
 	logging.Logger().Info().Str("addr", config.MetricsAddress).Msg("metrics server started")
 
+	pool := newProverPool(config.ProverWorkers, config.QueueDepth)
+	pool.start()
+
 	proverMux := http.NewServeMux()
-	proverMux.Handle("/prove", proveHandler{provingSystem: provingSystem, mode: config.Mode})
-	proverServer := &http.Server{Addr: config.ProverAddress, Handler: proverMux}
-	proverJob := spawnServerJob(proverServer, "prover server")
+	proverMux.Handle("/prove", proveHandler{system: system, mode: config.Mode, pool: pool, maxProvingTime: config.MaxProvingTime})
+	jobStore := config.JobStore
+	if jobStore == nil {
+		jobStore = NewMemoryJobStore(config.JobRetention)
+	}
+	jobs := newJobsHandler(config.Mode, system, jobStore, pool, config.MaxProvingTime)
+	proverMux.Handle("/jobs", jobs)
+	proverMux.Handle("/jobs/", jobs)
+	proverMux.Handle("/verify", verifyHandler{system: system, mode: config.Mode})
+	proverMux.Handle("/healthz", healthHandler{})
+	proverMux.Handle("/readyz", readyHandler{system: system, pool: pool})
+	proverMux.Handle("/info", infoHandler{system: system, mode: config.Mode})
+	newProverServer := func() *http.Server {
+		return &http.Server{Addr: config.ProverAddress, Handler: proverMux}
+	}
+	proverServerJob := superviseServer(newProverServer, "prover server", config.RestartPolicy)
+	drainTimeout := config.DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	proverJob := spawnProverServerJob(proverServerJob, pool, jobs, drainTimeout)
 	logging.Logger().Info().Str("addr", config.ProverAddress).Msg("app server started")
 
-	return CombineJobs(metricsJob, proverJob)
+	if config.GrpcAddress == "" {
+		return CombineJobs(metricsJob, proverJob)
+	}
+	service := &grpcService{
+		mode:           config.Mode,
+		system:         system,
+		pool:           pool,
+		jobs:           jobs,
+		maxProvingTime: config.MaxProvingTime,
+	}
+	spawnGrpcServer := func() RunningJob {
+		grpcServer := grpc.NewServer()
+		pb.RegisterProverServer(grpcServer, service)
+		return spawnGrpcServerJob(grpcServer, config.GrpcAddress, "grpc server")
+	}
+	grpcJob := Supervise("grpc server", spawnGrpcServer, config.RestartPolicy)
+	logging.Logger().Info().Str("addr", config.GrpcAddress).Msg("grpc server started")
+
+	return CombineJobs(metricsJob, proverJob, grpcJob)
 }
 
 type proveHandler struct {
-	mode          string
-	provingSystem *prover.ProvingSystem
+	mode           string
+	system         *SystemHolder
+	pool           *proverPool
+	maxProvingTime time.Duration
+}
+
+// proveContext derives the context of a proving request from parent, applying
+// the deadline requested in the ProveTimeoutHeader, capped at maxProvingTime.
+func proveContext(parent context.Context, r *http.Request, maxProvingTime time.Duration) (context.Context, context.CancelFunc, *Error) {
+	timeout := maxProvingTime
+	if value := r.Header.Get(ProveTimeoutHeader); value != "" {
+		requested, err := time.ParseDuration(value)
+		if err != nil || requested <= 0 {
+			return nil, nil, invalidTimeoutError(value)
+		}
+		if timeout == 0 || requested < timeout {
+			timeout = requested
+		}
+	}
+	if timeout == 0 {
+		ctx, cancel := context.WithCancel(parent)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return ctx, cancel, nil
 }
 
 func (handler proveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -104,37 +358,28 @@ func (handler proveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logging.Logger().Info().Msg("received prove request")
-	buf, err := io.ReadAll(r.Body)
-	if err != nil {
-		malformedBodyError(err).send(w)
+	provingSystem := handler.system.Get()
+	if provingSystem == nil {
+		notReadyError().send(w)
 		return
 	}
+	// The request context is cancelled when the client disconnects.
+	ctx, cancel, timeoutErr := proveContext(r.Context(), r, handler.maxProvingTime)
+	if timeoutErr != nil {
+		timeoutErr.send(w)
+		return
+	}
+	defer cancel()
 
-	var proof *prover.Proof
-	if handler.mode == InsertionMode {
-		var params prover.InsertionParameters
-
-		err = json.Unmarshal(buf, &params)
-		if err != nil {
-			malformedBodyError(err).send(w)
-			return
-		}
-
-		proof, err = handler.provingSystem.ProveInsertion(&params)
-	} else if handler.mode == DeletionMode {
-		var params prover.DeletionParameters
-
-		err = json.Unmarshal(buf, &params)
-		if err != nil {
-			malformedBodyError(err).send(w)
-			return
-		}
-
-		proof, err = handler.provingSystem.ProveDeletion(&params)
+	request, requestErr := readProofRequest(handler.mode, provingSystem, r)
+	if requestErr != nil {
+		requestErr.send(w)
+		return
 	}
 
-	if err != nil {
-		provingError(err).send(w)
+	proof, proveErr := proveOnPool(ctx, handler.pool, handler.system, request)
+	if proveErr != nil {
+		proveErr.send(w)
 		return
 	}
 
@@ -151,3 +396,127 @@ func (handler proveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		logging.Logger().Error().Err(err).Msg("error writing response")
 	}
 }
+
+// proveOnPool runs the request on the proving pool and waits for the proof,
+// giving up once ctx is done or the pool abandons its tasks.
+func proveOnPool(ctx context.Context, pool *proverPool, system *SystemHolder, request *proofRequest) (*prover.Proof, *Error) {
+	var proof *prover.Proof
+	var proveErr *Error
+	done := make(chan struct{})
+	err := pool.submit(ctx, func(ctx context.Context) {
+		defer close(done)
+		proof, proveErr = request.prove(ctx, system.Get())
+	})
+	if err != nil {
+		return nil, pool.submitError(err)
+	}
+	select {
+	case <-done:
+		return proof, proveErr
+	case <-pool.abandoned():
+		return nil, shuttingDownError()
+	case <-ctx.Done():
+		// The worker finishes on its own at the next point where the
+		// prover checks the context.
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// contextError converts the error of a done context to the response sent to
+// the client.
+func contextError(err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return deadlineExceededError()
+	}
+	return cancelledError()
+}
+
+// BinaryContentType marks proving requests whose body holds the parameters in
+// the binary encoding of the prover package instead of JSON.
+const BinaryContentType = "application/octet-stream"
+
+// proofRequest holds the parsed parameters of a single proving request. Exactly
+// one of the fields is set, depending on the mode the server runs in and on
+// the encoding of the request. The binary encoding is decoded straight into a
+// witness.
+type proofRequest struct {
+	insertion *prover.InsertionParameters
+	deletion  *prover.DeletionParameters
+	witness   *prover.Witness
+}
+
+// readProofRequest reads the parameters of a proving request from its body,
+// as JSON or, when sent with the BinaryContentType, in the binary encoding.
+func readProofRequest(mode string, provingSystem *prover.ProvingSystem, r *http.Request) (*proofRequest, *Error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == BinaryContentType {
+		witness, err := provingSystem.ReadWitness(r.Body)
+		if err != nil {
+			return nil, decodeError(err, "")
+		}
+		if witness.Mode() != mode {
+			return nil, malformedBodyError(fmt.Errorf("expected %s parameters, got %s parameters", mode, witness.Mode()))
+		}
+		return &proofRequest{witness: witness}, nil
+	}
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, malformedBodyError(err)
+	}
+	return parseProofRequest(mode, buf, "")
+}
+
+// parseProofRequest parses parameters in JSON, found at path in the body of
+// the request.
+func parseProofRequest(mode string, buf []byte, path string) (*proofRequest, *Error) {
+	var request proofRequest
+	if mode == InsertionMode {
+		request.insertion = new(prover.InsertionParameters)
+		err := json.Unmarshal(buf, request.insertion)
+		if err != nil {
+			return nil, decodeError(err, path)
+		}
+	} else if mode == DeletionMode {
+		request.deletion = new(prover.DeletionParameters)
+		err := json.Unmarshal(buf, request.deletion)
+		if err != nil {
+			return nil, decodeError(err, path)
+		}
+	}
+	return &request, nil
+}
+
+// computeInputHash computes the public input of the proof from the parameters
+// of the request.
+func (request *proofRequest) computeInputHash() big.Int {
+	if request.insertion != nil {
+		request.insertion.ComputeInputHashInsertion()
+		return request.insertion.InputHash
+	}
+	request.deletion.ComputeInputHashDeletion()
+	return request.deletion.InputHash
+}
+
+func (request *proofRequest) prove(ctx context.Context, provingSystem *prover.ProvingSystem) (*prover.Proof, *Error) {
+	var proof *prover.Proof
+	var err error
+	if request.insertion != nil {
+		proof, err = provingSystem.ProveInsertionContext(ctx, request.insertion)
+	} else if request.deletion != nil {
+		proof, err = provingSystem.ProveDeletionContext(ctx, request.deletion)
+	} else if request.witness != nil {
+		proof, err = provingSystem.ProveWitnessContext(ctx, request.witness)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return nil, contextError(err)
+	}
+	var validationErr *prover.ValidationError
+	if errors.As(err, &validationErr) {
+		return nil, invalidParametersError(validationErr)
+	}
+	if err != nil {
+		return nil, provingError(err)
+	}
+	return proof, nil
+}
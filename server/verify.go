@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"worldcoin/gnark-mbu/logging"
+
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// verifyRequestJSON is the body of a verification request. The public input
+// of the proof is either given directly as InputHash, or computed from the
+// full Parameters of the batch.
+type verifyRequestJSON struct {
+	Proof      *prover.Proof   `json:"proof"`
+	InputHash  string          `json:"inputHash,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+type verifyResponseJSON struct {
+	Valid     bool   `json:"valid"`
+	InputHash string `json:"inputHash"`
+	Message   string `json:"message,omitempty"`
+}
+
+type verifyHandler struct {
+	mode   string
+	system *SystemHolder
+}
+
+func (handler verifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	logging.Logger().Info().Msg("received verify request")
+	provingSystem := handler.system.Get()
+	if provingSystem == nil {
+		notReadyError().send(w)
+		return
+	}
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+
+	var request verifyRequestJSON
+	err = json.Unmarshal(buf, &request)
+	if err != nil {
+		// Only the proof holds field elements at this point.
+		decodeError(err, "proof.").send(w)
+		return
+	}
+	if request.Proof == nil {
+		malformedBodyError(fmt.Errorf("missing proof")).send(w)
+		return
+	}
+
+	// Parameters set to null are left out, like an empty inputHash.
+	hasParameters := len(request.Parameters) != 0 && string(request.Parameters) != "null"
+	var given *big.Int
+	if hasParameters {
+		if request.InputHash != "" {
+			malformedBodyError(fmt.Errorf("only one of inputHash and parameters can be given")).send(w)
+			return
+		}
+		params, paramsErr := parseProofRequest(handler.mode, request.Parameters, "parameters.")
+		if paramsErr != nil {
+			paramsErr.send(w)
+			return
+		}
+		computed := params.computeInputHash()
+		given = &computed
+	} else if request.InputHash != "" {
+		given = new(big.Int)
+		err = prover.ParseInputHash(given, request.InputHash, "inputHash")
+		if err != nil {
+			decodeError(err, "").send(w)
+			return
+		}
+	}
+	inputHash, inputErr := publicInput(given, request.Proof)
+	if inputErr != nil {
+		inputErr.send(w)
+		return
+	}
+	if inputHash == nil {
+		malformedBodyError(fmt.Errorf("missing inputHash or parameters")).send(w)
+		return
+	}
+
+	err = verifyProof(handler.mode, provingSystem, *inputHash, request.Proof)
+
+	response := verifyResponseJSON{Valid: err == nil, InputHash: fmt.Sprintf("0x%s", inputHash.Text(16))}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	logging.Logger().Info().Bool("valid", response.Valid).Msg("verification complete")
+	sendJSON(w, http.StatusOK, &response)
+}
+
+func inputHashMismatchError(expected *big.Int, actual *big.Int) *Error {
+	return &Error{
+		StatusCode: http.StatusBadRequest,
+		Code:       "input_hash_mismatch",
+		Message:    fmt.Sprintf("proof is for input hash 0x%s, expected 0x%s", actual.Text(16), expected.Text(16)),
+		Details:    &ErrorDetails{Kind: prover.InvalidInputHash, Expected: "0x" + expected.Text(16), Actual: "0x" + actual.Text(16)},
+	}
+}
+
+// publicInput returns the input hash to verify the proof against: the one
+// given or computed from the parameters, or else the one the proof carries.
+// When both are known, they must be the same field element. It returns nil
+// when neither is.
+func publicInput(given *big.Int, proof *prover.Proof) (*big.Int, *Error) {
+	if given == nil {
+		return proof.InputHash, nil
+	}
+	if proof.InputHash != nil {
+		modulus := ecc.BN254.ScalarField()
+		if new(big.Int).Mod(given, modulus).Cmp(new(big.Int).Mod(proof.InputHash, modulus)) != 0 {
+			return nil, inputHashMismatchError(given, proof.InputHash)
+		}
+	}
+	return given, nil
+}
+
+func verifyProof(mode string, provingSystem *prover.ProvingSystem, inputHash big.Int, proof *prover.Proof) error {
+	if mode == InsertionMode {
+		return provingSystem.VerifyInsertion(inputHash, proof)
+	} else if mode == DeletionMode {
+		return provingSystem.VerifyDeletion(inputHash, proof)
+	}
+	return fmt.Errorf("invalid mode: %s", mode)
+}